@@ -11,13 +11,14 @@ import (
 
 var (
 	GlobalTimeout time.Duration
+	DefaultPoints int
 	Creds         map[uint]map[string]map[string]string
 
 	// Global list of all current CredData
 	CredLists []CredData
 )
 
-func getCreds(teamID uint, credLists []string, checkName string) (string, string) {
+func GetCreds(teamID uint, credLists []string, checkName string) (string, string) {
 	var usernameList CredData
 	if len(credLists) != 0 {
 		credList := credLists[rand.Intn(len(credLists))]
@@ -56,7 +57,12 @@ type Check interface {
 	FetchName() string
 	FetchDisplay() string
 	FetchIP() string
+	FetchPort() int
 	FetchAnonymous() bool
+	FetchTimeout() time.Duration
+	FetchRetries() int
+	FetchPoints() int
+	FetchDependsOn() []string
 }
 
 type Result struct {
@@ -66,15 +72,36 @@ type Result struct {
 	IP     string `json:"ip,omitempty"`
 	Error  string `json:"error,omitempty"`
 	Debug  string `json:"debug,omitempty"`
+
+	// Attempts is how many times the check ran this round
+	Attempts int `json:"attempts,omitempty"`
+
+	// Partial is the fraction (0 to 1) of a down check's
+	// points it earned, for checks that are partly working
+	Partial float64 `json:"partial,omitempty"`
+
+	// Weight is how many points the check is worth when up
+	Weight int `json:"-"`
+
+	// BlockedBy is the check that was down, if the check didn't
+	// run because something it depends on was down
+	BlockedBy string `json:"-"`
 }
 
-type checkBase struct {
+// CheckBase has the options every check has. Check types embed it.
+type CheckBase struct {
 	Name      string // Name is the box name plus the service (ex. lunar-dns)
 	Display   string // Display is the name of the service (ex. dns)
+	Box       string `toml:"-"` // Box is the name of the box the check is on
 	IP        string
 	CredLists []string
 	Port      int
 	Anonymous bool
+	Ipv6      bool     // use the box's ip6 address on a dual-stack box
+	Timeout   int      // seconds per attempt, defaults to the global timeout
+	Retries   int      // extra attempts in a round before the check is down
+	Points    int      // points per round when up, defaults to servicepoints
+	DependsOn []string // names of checks that have to be up for this check to run
 }
 
 type CredData struct {
@@ -83,41 +110,150 @@ type CredData struct {
 	DefaultPw string
 }
 
-func (c checkBase) FetchName() string {
+func (c *CheckBase) base() *CheckBase {
+	return c
+}
+
+func (c CheckBase) FetchName() string {
 	return c.Name
 }
 
-func (c checkBase) FetchDisplay() string {
+func (c CheckBase) FetchDisplay() string {
 	return c.Display
 }
 
-func (c checkBase) FetchIP() string {
+func (c CheckBase) FetchIP() string {
 	return c.IP
 }
 
-func (c checkBase) FetchAnonymous() bool {
+func (c CheckBase) FetchPort() int {
+	return c.Port
+}
+
+func (c CheckBase) FetchAnonymous() bool {
 	return c.Anonymous
 }
-func RunCheck(teamID uint, teamIP, boxIP, boxName string, check Check, wg *sync.WaitGroup, resChan chan Result) {
-	res := make(chan Result)
-	result := Result{}
+
+func (c CheckBase) FetchTimeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Second
+	}
+	return GlobalTimeout
+}
+
+// deadline is when the check should stop its own work, leaving time
+// to report what went wrong before RunCheck gives up on it.
+func (c CheckBase) deadline() time.Time {
+	return time.Now().Add(c.FetchTimeout() * 9 / 10)
+}
+
+func (c CheckBase) FetchRetries() int {
+	return c.Retries
+}
+
+func (c CheckBase) FetchPoints() int {
+	if c.Points != 0 {
+		return c.Points
+	}
+	return DefaultPoints
+}
+
+func (c CheckBase) FetchDependsOn() []string {
+	return c.DependsOn
+}
+
+// Round lets the checks for one team in a round wait
+// on the results of the checks they depend on.
+type Round struct {
+	results map[string]*roundResult
+}
+
+type roundResult struct {
+	done   chan struct{}
+	result Result
+}
+
+func NewRound(list []Check) *Round {
+	r := &Round{results: make(map[string]*roundResult)}
+	for _, c := range list {
+		r.results[c.FetchName()] = &roundResult{done: make(chan struct{})}
+	}
+	return r
+}
+
+// wait blocks until the check's dependencies are done, and returns
+// the check that caused the first down one to be down, if any.
+func (r *Round) wait(check Check) string {
+	for _, name := range check.FetchDependsOn() {
+		dep, ok := r.results[name]
+		if !ok {
+			continue
+		}
+		<-dep.done
+		if !dep.result.Status {
+			if dep.result.BlockedBy != "" {
+				return dep.result.BlockedBy
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+func (r *Round) finish(name string, result Result) {
+	if dep, ok := r.results[name]; ok {
+		dep.result = result
+		close(dep.done)
+	}
+}
+
+func RunCheck(teamID uint, teamIP, boxIP, boxName string, check Check, round *Round, wg *sync.WaitGroup, resChan chan Result) {
 	fullIP := strings.Replace(boxIP, "x", teamIP, 1)
-	go check.Run(teamID, fullIP, res)
-	select {
-	case result = <-res:
-	case <-time.After(GlobalTimeout):
-		result.Error = "Timed out"
+	result := Result{}
+	blockedBy := ""
+	if round != nil {
+		blockedBy = round.wait(check)
+	}
+	if blockedBy != "" {
+		// Don't pile on when the root cause is already down
+		result = Result{
+			Error:     "blocked by " + blockedBy,
+			Debug:     "check did not run, since " + blockedBy + " was down",
+			BlockedBy: blockedBy,
+		}
+	}
+	// Retry failed checks within the round before marking them down
+	for attempt := 1; blockedBy == "" && attempt <= check.FetchRetries()+1; attempt++ {
+		// Buffered so a check that times out can still send and exit
+		res := make(chan Result, 1)
+		go check.Run(teamID, fullIP, res)
+		select {
+		case result = <-res:
+		case <-time.After(check.FetchTimeout()):
+			result = Result{Error: "Timed out"}
+		}
+		result.Attempts = attempt
+		if result.Status {
+			break
+		}
 	}
 	result.Name = check.FetchName()
 	result.IP = fullIP
 	result.Box = boxName
+	result.Weight = check.FetchPoints()
+	if round != nil {
+		round.finish(check.FetchName(), result)
+	}
 	resChan <- result
 	wg.Done()
 }
 
-func tcpCheck(hostIP string) error {
-	_, err := net.DialTimeout("tcp", hostIP, GlobalTimeout)
-	return err
+func tcpCheck(hostIP string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", hostIP, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
 
 /*
@@ -0,0 +1,116 @@
+package checks
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTcpCheckCloses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if err := tcpCheck(ln.Addr().String(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The check hangs up, so the server sees EOF instead of waiting
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection left open: %v", err)
+	}
+}
+
+// fakeCheck reports up or down without touching the network.
+type fakeCheck struct {
+	CheckBase
+	up bool
+}
+
+func (c fakeCheck) Run(teamID uint, boxIp string, res chan Result) {
+	if c.up {
+		res <- Result{Status: true}
+		return
+	}
+	res <- Result{Error: "down"}
+}
+
+func TestRoundDependencies(t *testing.T) {
+	check := func(name string, up bool, dependsOn ...string) Check {
+		return fakeCheck{CheckBase: CheckBase{Name: name, Timeout: 1, DependsOn: dependsOn}, up: up}
+	}
+
+	tests := []struct {
+		name      string
+		checks    []Check
+		blockedBy map[string]string // checks that shouldn't run
+		up        map[string]bool
+	}{
+		{
+			name:   "dependency up",
+			checks: []Check{check("web", true, "dns"), check("dns", true)},
+			up:     map[string]bool{"web": true, "dns": true},
+		},
+		{
+			name:      "dependency down",
+			checks:    []Check{check("web", true, "dns"), check("dns", false)},
+			blockedBy: map[string]string{"web": "dns"},
+		},
+		{
+			name:      "root cause is passed on",
+			checks:    []Check{check("shop", true, "web"), check("web", true, "dns"), check("dns", false)},
+			blockedBy: map[string]string{"web": "dns", "shop": "dns"},
+		},
+		{
+			name:      "first down dependency",
+			checks:    []Check{check("web", true, "ldap", "dns"), check("dns", false), check("ldap", true)},
+			up:        map[string]bool{"ldap": true},
+			blockedBy: map[string]string{"web": "dns"},
+		},
+		{
+			name:   "dependency down on its own",
+			checks: []Check{check("web", false, "dns"), check("dns", true)},
+			up:     map[string]bool{"dns": true},
+		},
+		{
+			name:   "dependency not in the round",
+			checks: []Check{check("web", true, "dns")},
+			up:     map[string]bool{"web": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			round := NewRound(tt.checks)
+			results := make(chan Result, len(tt.checks))
+			var wg sync.WaitGroup
+			for _, c := range tt.checks {
+				wg.Add(1)
+				go RunCheck(1, "1", "10.20.x.1", "castle", c, round, &wg, results)
+			}
+			wg.Wait()
+			close(results)
+
+			for res := range results {
+				if res.Status != tt.up[res.Name] || res.BlockedBy != tt.blockedBy[res.Name] {
+					t.Errorf("%s: status %v, blocked by %q", res.Name, res.Status, res.BlockedBy)
+				}
+				if res.IP != "10.20.1.1" || res.Box != "castle" || res.Weight != DefaultPoints {
+					t.Errorf("%s: ip %s, box %s, weight %d", res.Name, res.IP, res.Box, res.Weight)
+				}
+				if res.BlockedBy != "" && res.Attempts != 0 {
+					t.Errorf("%s: blocked check ran %d times", res.Name, res.Attempts)
+				}
+			}
+		})
+	}
+}
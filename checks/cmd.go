@@ -9,11 +9,15 @@ import (
 )
 
 type Cmd struct {
-	checkBase
+	CheckBase
 	Command string
 	Regex   string
 }
 
+func init() {
+	Register("cmd", Definition{Check: Cmd{}})
+}
+
 func commandOutput(cmd string) (string, error) {
 
 	out, err := exec.Command("/bin/sh", "-c", cmd).Output()
@@ -33,7 +37,7 @@ func (c Cmd) Run(teamID uint, boxIp string, res chan Result) {
 		return
 	}
 
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
 
 	// Replace command input keywords
 	formedCommand := strings.Replace(c.Command, "BOXIP", boxIp, -1)
@@ -65,3 +69,10 @@ func (c Cmd) Run(teamID uint, boxIp string, res chan Result) {
 		Debug:  out,
 	}
 }
+
+func (c *Cmd) Validate() error {
+	if len(c.CredLists) < 1 && !strings.Contains(c.Command, "USERNAME") && !strings.Contains(c.Command, "PASSWORD") {
+		c.Anonymous = true
+	}
+	return nil
+}
@@ -2,16 +2,28 @@ package checks
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
 type Dns struct {
-	checkBase
-	Record []DnsRecord
+	CheckBase
+	Transport   string
+	AxfrZone    string
+	Dnssec      bool
+	TrustAnchor string
+	Record      []DnsRecord
+}
+
+func init() {
+	Register("dns", Definition{Check: Dns{}, Port: 53, Anonymous: true})
 }
 
 type DnsRecord struct {
@@ -23,27 +35,25 @@ type DnsRecord struct {
 func (c Dns) Run(teamID uint, boxIp string, res chan Result) {
 	// Pick a record
 	record := c.Record[rand.Intn(len(c.Record))]
-	fqdn := dns.Fqdn(record.Domain)
 	answerList := fmt.Sprint(record.Answer)
+	addr := net.JoinHostPort(boxIp, strconv.Itoa(c.Port))
+
+	// PTR records can be given as the IP to look up
+	domain := record.Domain
+	if record.Kind == "PTR" && net.ParseIP(domain) != nil {
+		domain, _ = dns.ReverseAddr(domain)
+	}
+	qtype := dns.StringToType[record.Kind]
 
 	// Setup for dns query
 	var msg dns.Msg
-
-	// switch of kind of record (A, MX, etc)
-	// TODO: add more values
-	switch record.Kind {
-	case "A":
-		msg.SetQuestion(fqdn, dns.TypeA)
-	case "MX":
-		msg.SetQuestion(fqdn, dns.TypeMX)
+	msg.SetQuestion(dns.Fqdn(domain), qtype)
+	if c.Dnssec {
+		msg.SetEdns0(4096, true)
 	}
 
-	// Make it obey timeout via deadline
-	deadctx, cancel := context.WithDeadline(context.TODO(), time.Now().Add(GlobalTimeout))
-	defer cancel()
-
 	// Send the query
-	in, err := dns.ExchangeContext(deadctx, &msg, fmt.Sprintf("%s:%d", boxIp, c.Port))
+	in, err := c.exchange(&msg, addr)
 	if err != nil {
 		res <- Result{
 			Error: "error sending query",
@@ -61,24 +71,339 @@ func (c Dns) Run(teamID uint, boxIp string, res chan Result) {
 		return
 	}
 
-	// Loop through results and check for correct match
+	// Loop through results and check for correct match. Answers of
+	// other types (like a CNAME chain in front of an A record) are
+	// skipped.
+	matched := ""
 	for _, answer := range in.Answer {
-		// Check if an answer is an A record and it matches the expected IP
+		value, ok := dnsAnswerValue(answer, qtype)
+		if !ok {
+			continue
+		}
 		for _, expectedAnswer := range record.Answer {
-			if a, ok := answer.(*dns.A); ok && (a.A).String() == expectedAnswer {
-				res <- Result{
-					Status: true,
-					Error:  "record " + record.Domain + " returned " + expectedAnswer,
-					Debug:  "acceptable answers were: " + answerList,
-				}
-				return
+			if value == normalizeDnsAnswer(expectedAnswer, qtype) {
+				matched = expectedAnswer
+				break
+			}
+		}
+		if matched != "" {
+			break
+		}
+	}
+
+	// If we reach here no records matched expected answer and check fails
+	if matched == "" {
+		res <- Result{
+			Error: "incorrect answer(s) received from DNS",
+			Debug: "acceptable answers were: " + answerList + "," + " received " + fmt.Sprint(in.Answer),
+		}
+		return
+	}
+
+	if c.Dnssec {
+		err := c.validateDnssec(in, addr)
+		if err != nil {
+			res <- Result{
+				Error: "dnssec validation failed for " + record.Domain,
+				Debug: err.Error(),
+			}
+			return
+		}
+	}
+
+	if c.AxfrZone != "" {
+		if count := c.axfrRecords(addr); count > 0 {
+			res <- Result{
+				Error: "zone transfer allowed for " + c.AxfrZone,
+				Debug: "transferred " + strconv.Itoa(count) + " records",
 			}
+			return
 		}
 	}
 
-	// If we reach here no records matched expected IP and check fails
 	res <- Result{
-		Error: "incorrect answer(s) received from DNS",
-		Debug: "acceptable answers were: " + answerList + "," + " received " + fmt.Sprint(in.Answer),
+		Status: true,
+		Error:  "record " + record.Domain + " returned " + matched,
+		Debug:  "acceptable answers were: " + answerList,
+	}
+}
+
+// exchange sends msg over the configured transport, falling back
+// to TCP if a UDP response was truncated.
+func (c Dns) exchange(msg *dns.Msg, addr string) (*dns.Msg, error) {
+	// Make it obey timeout via deadline
+	deadctx, cancel := context.WithDeadline(context.TODO(), time.Now().Add(c.FetchTimeout()))
+	defer cancel()
+
+	client := dns.Client{Net: c.Transport, Timeout: c.FetchTimeout()}
+	in, _, err := client.ExchangeContext(deadctx, msg, addr)
+	if err == nil && in.Truncated && c.Transport != "tcp" {
+		client.Net = "tcp"
+		in, _, err = client.ExchangeContext(deadctx, msg, addr)
+	}
+	return in, err
+}
+
+// axfrRecords tries to transfer the configured zone, returning
+// how many records were transferred (zero if it was refused).
+func (c Dns) axfrRecords(addr string) int {
+	var msg dns.Msg
+	msg.SetAxfr(dns.Fqdn(c.AxfrZone))
+	transfer := dns.Transfer{
+		DialTimeout:  c.FetchTimeout(),
+		ReadTimeout:  c.FetchTimeout(),
+		WriteTimeout: c.FetchTimeout(),
+	}
+	envelopes, err := transfer.In(&msg, addr)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for env := range envelopes {
+		if env.Error != nil {
+			break
+		}
+		count += len(env.RR)
+	}
+	return count
+}
+
+// validateDnssec checks that every RRset in the answer is signed
+// by the zone's DNSKEY, and that the DNSKEY set is signed by a key
+// matching the configured trust anchor.
+func (c Dns) validateDnssec(in *dns.Msg, addr string) error {
+	anchors, err := loadTrustAnchor(c.TrustAnchor)
+	if err != nil {
+		return errors.New("could not load trust anchor: " + err.Error())
+	}
+
+	// Group the answer into RRsets and their signatures
+	rrsets := map[string][]dns.RR{}
+	sigs := map[string][]*dns.RRSIG{}
+	for _, rr := range in.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := strings.ToLower(sig.Hdr.Name) + "/" + dns.TypeToString[sig.TypeCovered]
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+		key := strings.ToLower(rr.Header().Name) + "/" + dns.TypeToString[rr.Header().Rrtype]
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	keysByZone := map[string][]*dns.DNSKEY{}
+	for name, rrset := range rrsets {
+		if len(sigs[name]) == 0 {
+			return errors.New("no RRSIG for " + name)
+		}
+
+		var lastErr error
+		valid := false
+		for _, sig := range sigs[name] {
+			zone := strings.ToLower(sig.SignerName)
+			if _, ok := keysByZone[zone]; !ok {
+				keys, err := c.fetchTrustedKeys(zone, addr, anchors)
+				if err != nil {
+					return err
+				}
+				keysByZone[zone] = keys
+			}
+			lastErr = verifyRRSIG(sig, keysByZone[zone], rrset)
+			if lastErr == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errors.New("signature for " + name + " invalid: " + lastErr.Error())
+		}
+	}
+	return nil
+}
+
+// fetchTrustedKeys queries the zone's DNSKEY set and returns it
+// once it has been verified against the trust anchor.
+func (c Dns) fetchTrustedKeys(zone, addr string, anchors []dns.RR) ([]*dns.DNSKEY, error) {
+	var msg dns.Msg
+	msg.SetQuestion(dns.Fqdn(zone), dns.TypeDNSKEY)
+	msg.SetEdns0(4096, true)
+	in, err := c.exchange(&msg, addr)
+	if err != nil {
+		return nil, errors.New("error querying DNSKEY for " + zone + ": " + err.Error())
+	}
+
+	keySet := []dns.RR{}
+	keys := []*dns.DNSKEY{}
+	sigs := []*dns.RRSIG{}
+	for _, rr := range in.Answer {
+		switch r := rr.(type) {
+		case *dns.DNSKEY:
+			keySet = append(keySet, r)
+			keys = append(keys, r)
+		case *dns.RRSIG:
+			if r.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, r)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no DNSKEY records for " + zone)
+	}
+
+	trusted := []*dns.DNSKEY{}
+	for _, key := range keys {
+		if matchesTrustAnchor(key, anchors) {
+			trusted = append(trusted, key)
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, errors.New("no DNSKEY for " + zone + " matches the trust anchor")
+	}
+
+	for _, sig := range sigs {
+		if verifyRRSIG(sig, trusted, keySet) == nil {
+			return keys, nil
+		}
+	}
+	return nil, errors.New("DNSKEY set for " + zone + " is not signed by the trust anchor")
+}
+
+func verifyRRSIG(sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	if !sig.ValidityPeriod(time.Now()) {
+		return errors.New("signature expired or not yet valid")
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, rrset); err == nil {
+			return nil
+		}
+	}
+	return errors.New("no matching key verified signature with key tag " + strconv.Itoa(int(sig.KeyTag)))
+}
+
+// loadTrustAnchor reads DNSKEY or DS records from a zone
+// file in checkfiles/.
+func loadTrustAnchor(fileName string) ([]dns.RR, error) {
+	content, err := GetFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	anchors := []dns.RR{}
+	zp := dns.NewZoneParser(strings.NewReader(content), "", fileName)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch rr.(type) {
+		case *dns.DNSKEY, *dns.DS:
+			anchors = append(anchors, rr)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, errors.New("no DNSKEY or DS records in " + fileName)
+	}
+	return anchors, nil
+}
+
+func matchesTrustAnchor(key *dns.DNSKEY, anchors []dns.RR) bool {
+	for _, anchor := range anchors {
+		switch a := anchor.(type) {
+		case *dns.DNSKEY:
+			if a.Algorithm == key.Algorithm && a.PublicKey == key.PublicKey && a.Flags == key.Flags {
+				return true
+			}
+		case *dns.DS:
+			if ds := key.ToDS(a.DigestType); ds != nil && strings.EqualFold(ds.Digest, a.Digest) && ds.KeyTag == a.KeyTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dnsAnswerValue returns the part of an answer that's compared
+// against the configured answers, if it's the type asked for.
+func dnsAnswerValue(answer dns.RR, qtype uint16) (string, bool) {
+	if answer.Header().Rrtype != qtype {
+		return "", false
+	}
+	var value string
+	switch a := answer.(type) {
+	case *dns.A:
+		value = a.A.String()
+	case *dns.AAAA:
+		value = a.AAAA.String()
+	case *dns.MX:
+		value = a.Mx
+	case *dns.TXT:
+		value = strings.Join(a.Txt, "")
+	case *dns.SRV:
+		value = a.Target + ":" + strconv.Itoa(int(a.Port))
+	case *dns.PTR:
+		value = a.Ptr
+	case *dns.NS:
+		value = a.Ns
+	case *dns.SOA:
+		value = a.Ns
+	case *dns.CNAME:
+		value = a.Target
+	default:
+		return "", false
+	}
+	return normalizeDnsAnswer(value, qtype), true
+}
+
+// normalizeDnsAnswer makes answers comparable: IPs are reformatted,
+// and names are lowercased without the trailing dot.
+func normalizeDnsAnswer(answer string, qtype uint16) string {
+	switch qtype {
+	case dns.TypeTXT:
+		return answer
+	case dns.TypeA, dns.TypeAAAA:
+		if ip := net.ParseIP(answer); ip != nil {
+			return ip.String()
+		}
+		return answer
+	case dns.TypeSRV:
+		host, port, err := net.SplitHostPort(answer)
+		if err != nil {
+			return strings.ToLower(answer)
+		}
+		return net.JoinHostPort(strings.ToLower(strings.TrimSuffix(host, ".")), port)
+	}
+	return strings.ToLower(strings.TrimSuffix(answer, "."))
+}
+
+func (c *Dns) Validate() error {
+	if len(c.Record) < 1 {
+		return errors.New("dns check " + c.Name + " has no records")
+	}
+	if c.Transport == "" {
+		c.Transport = "udp"
+	}
+	if c.Transport != "udp" && c.Transport != "tcp" {
+		return errors.New("dns transport must be udp or tcp for " + c.Name)
+	}
+	if c.Dnssec {
+		if c.TrustAnchor == "" {
+			return errors.New("dnssec validation needs a trust anchor for " + c.Name)
+		}
+		if _, err := GetFile(c.TrustAnchor); err != nil {
+			return errors.New("can't read dns trust anchor for " + c.Name + ": " + err.Error())
+		}
+	}
+	for k, r := range c.Record {
+		r.Kind = strings.ToUpper(r.Kind)
+		switch r.Kind {
+		case "A", "AAAA", "MX", "TXT", "SRV", "PTR", "NS", "SOA", "CNAME":
+		default:
+			return errors.New("unsupported dns record kind for " + c.Name + ": " + r.Kind)
+		}
+		if len(r.Answer) < 1 {
+			return errors.New("dns record " + r.Domain + " has no answers")
+		}
+		c.Record[k].Kind = r.Kind
 	}
+	return nil
 }
@@ -0,0 +1,143 @@
+package checks
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestDnsAnswerValue(t *testing.T) {
+	tests := []struct {
+		answer string
+		qtype  uint16
+		want   string
+		ok     bool
+	}{
+		{"castle.sherwood.lan. 300 IN A 10.20.1.5", dns.TypeA, "10.20.1.5", true},
+		{"castle.sherwood.lan. 300 IN AAAA 2001:0db8:0000::0001", dns.TypeAAAA, "2001:db8::1", true},
+		{"sherwood.lan. 300 IN MX 10 Mail.Sherwood.LAN.", dns.TypeMX, "mail.sherwood.lan", true},
+		{`sherwood.lan. 300 IN TXT "v=spf1 " "-all"`, dns.TypeTXT, "v=spf1 -all", true},
+		{"_ldap._tcp.sherwood.lan. 300 IN SRV 0 100 389 DC.sherwood.lan.", dns.TypeSRV, "dc.sherwood.lan:389", true},
+		{"5.1.20.10.in-addr.arpa. 300 IN PTR castle.sherwood.lan.", dns.TypePTR, "castle.sherwood.lan", true},
+		{"sherwood.lan. 300 IN NS ns1.sherwood.lan.", dns.TypeNS, "ns1.sherwood.lan", true},
+		{"sherwood.lan. 300 IN SOA ns1.sherwood.lan. admin.sherwood.lan. 1 7200 3600 1209600 300", dns.TypeSOA, "ns1.sherwood.lan", true},
+		{"www.sherwood.lan. 300 IN CNAME castle.sherwood.lan.", dns.TypeCNAME, "castle.sherwood.lan", true},
+		// A CNAME in the answer to an A query isn't the answer itself
+		{"www.sherwood.lan. 300 IN CNAME castle.sherwood.lan.", dns.TypeA, "", false},
+		{"sherwood.lan. 300 IN HINFO \"x86\" \"linux\"", dns.TypeHINFO, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			got, ok := dnsAnswerValue(mustRR(t, tt.answer), tt.qtype)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizeDnsAnswer(t *testing.T) {
+	tests := []struct {
+		answer string
+		qtype  uint16
+		want   string
+	}{
+		{"10.20.1.5", dns.TypeA, "10.20.1.5"},
+		{"2001:DB8:0::1", dns.TypeAAAA, "2001:db8::1"},
+		{"not an ip", dns.TypeA, "not an ip"},
+		{"Mail.Sherwood.lan.", dns.TypeMX, "mail.sherwood.lan"},
+		{"Mail.Sherwood.lan", dns.TypeCNAME, "mail.sherwood.lan"},
+		{"Case Matters.", dns.TypeTXT, "Case Matters."},
+		{"DC.sherwood.lan.:389", dns.TypeSRV, "dc.sherwood.lan:389"},
+		{"DC.sherwood.lan", dns.TypeSRV, "dc.sherwood.lan"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeDnsAnswer(tt.answer, tt.qtype); got != tt.want {
+			t.Errorf("normalizeDnsAnswer(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+}
+
+func TestDnssecSignatures(t *testing.T) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "sherwood.lan.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := *key
+	if _, err := other.Generate(256); err != nil {
+		t.Fatal(err)
+	}
+
+	rrset := []dns.RR{mustRR(t, "castle.sherwood.lan. 300 IN A 10.20.1.5")}
+	sign := func(inception, expiration time.Time) *dns.RRSIG {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: "castle.sherwood.lan.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+			KeyTag:     key.KeyTag(),
+			SignerName: key.Hdr.Name,
+			Algorithm:  key.Algorithm,
+			Inception:  uint32(inception.Unix()),
+			Expiration: uint32(expiration.Unix()),
+		}
+		if err := sig.Sign(priv.(crypto.Signer), rrset); err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	now := time.Now()
+	valid := sign(now.Add(-time.Hour), now.Add(time.Hour))
+	expired := sign(now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	tests := []struct {
+		name string
+		sig  *dns.RRSIG
+		keys []*dns.DNSKEY
+		ok   bool
+	}{
+		{"valid", valid, []*dns.DNSKEY{&other, key}, true},
+		{"expired", expired, []*dns.DNSKEY{key}, false},
+		{"wrong key", valid, []*dns.DNSKEY{&other}, false},
+		{"no keys", valid, nil, false},
+	}
+	for _, tt := range tests {
+		if err := verifyRRSIG(tt.sig, tt.keys, rrset); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	// Anchors can be the key itself or its DS record
+	ds := key.ToDS(dns.SHA256)
+	anchors := []struct {
+		name    string
+		anchors []dns.RR
+		ok      bool
+	}{
+		{"dnskey", []dns.RR{key}, true},
+		{"ds", []dns.RR{ds}, true},
+		{"other key", []dns.RR{&other}, false},
+		{"other ds", []dns.RR{other.ToDS(dns.SHA256)}, false},
+	}
+	for _, tt := range anchors {
+		if got := matchesTrustAnchor(key, tt.anchors); got != tt.ok {
+			t.Errorf("%s anchor: got %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
@@ -4,20 +4,72 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
 
-	"github.com/pmezard/go-difflib/difflib"
+	"github.com/google/uuid"
 )
 
-// FileDifference returns the percentage difference
+// diffTokens splits content into runs of word characters and single
+// punctuation characters, so pages without line breaks still compare
+// piece by piece.
+var diffTokens = regexp.MustCompile(`\w+|[^\w\s]`)
+
+// FileDifference returns the percentage similarity
 // between the contents of the filename passed and
-// the contents of the file passed.
+// the contents of the file passed, compared word by word.
 func FileDifference(fileName string, fileContent string) (int, error) {
 	originalFileContent, err := GetFile(fileName)
 	if err != nil {
 		return 0, err
 	}
-	diffMatcher := difflib.NewMatcher([]string{originalFileContent}, []string{fileContent})
-	return int((diffMatcher.Ratio() + 0.5) * 100), nil
+	return similarity(originalFileContent, fileContent), nil
+}
+
+// similarity returns the percentage of runs of three tokens that a
+// and b share. It's linear in the size of the pages, unlike a diff,
+// which can take minutes on big pages that repeat themselves.
+func similarity(a, b string) int {
+	shinglesA := shingles(a)
+	shinglesB := shingles(b)
+	total := 0
+	for _, count := range shinglesA {
+		total += count
+	}
+	for _, count := range shinglesB {
+		total += count
+	}
+	if total == 0 {
+		return 100
+	}
+	shared := 0
+	for shingle, count := range shinglesA {
+		if other := shinglesB[shingle]; other < count {
+			shared += other
+		} else {
+			shared += count
+		}
+	}
+	return int(float64(2*shared)/float64(total)*100 + 0.5)
+}
+
+// shingles counts each run of three tokens in content. Content
+// with fewer tokens is one shingle.
+func shingles(content string) map[string]int {
+	tokens := diffTokens.FindAllString(content, -1)
+	counts := map[string]int{}
+	if len(tokens) == 0 {
+		return counts
+	}
+	if len(tokens) < 3 {
+		counts[strings.Join(tokens, "\x00")]++
+		return counts
+	}
+	for i := 0; i+3 <= len(tokens); i++ {
+		counts[strings.Join(tokens[i:i+3], "\x00")]++
+	}
+	return counts
 }
 
 // FileHash returns the sha256sum of the filename
@@ -54,3 +106,10 @@ func GetFile(fileName string) (string, error) {
 func hexEncode(inputString string) string {
 	return hex.EncodeToString([]byte(inputString))
 }
+
+// uploadFile returns a unique file name in the upload directory
+// and random content, for checks that write a file and read it back.
+func uploadFile(dir string) (string, []byte) {
+	name := path.Join(dir, "dwayne-"+uuid.New().String()+".txt")
+	return name, []byte("DWAYNE-INATOR-5000 " + uuid.New().String() + "\n")
+}
@@ -0,0 +1,37 @@
+package checks
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	page := `<!DOCTYPE html><html><head><title>Sherwood Archery</title></head><body><h1>Welcome to Sherwood</h1><p>Longbows, arrows, and quivers for every outlaw.</p><ul><li>Longbow</li><li>Recurve</li><li>Crossbow</li></ul></body></html>`
+
+	// Big pages that repeat themselves used to take minutes to diff
+	catalog := strings.Repeat(`<div class="item"><a href="/item/1">Longbow</a><span>$40</span></div>`, 2000)
+
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max int
+	}{
+		{"identical", page, page, 100, 100},
+		{"minified small edit", page, strings.Replace(page, "every outlaw", "every merry man", 1), 90, 99},
+		{"minified defaced", page, `<html><body><h1>pwned by red team</h1></body></html>`, 10, 50},
+		{"formatted the same", page, strings.Replace(page, "><", ">\n<", -1), 100, 100},
+		{"big page small edit", catalog, strings.Replace(catalog, "Longbow", "Shortbow", 500), 95, 99},
+		{"big page cut in half", catalog, catalog[:len(catalog)/2], 66, 67},
+		{"empty", page, "", 0, 0},
+		{"both empty", "", "", 100, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := similarity(tt.a, tt.b)
+			if got < tt.min || got > tt.max {
+				t.Errorf("got %d%%, want %d-%d%%", got, tt.min, tt.max)
+			}
+		})
+	}
+}
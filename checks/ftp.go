@@ -1,8 +1,11 @@
 package checks
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"regexp"
 	"strconv"
 
@@ -10,8 +13,13 @@ import (
 )
 
 type Ftp struct {
-	checkBase
-	File []FtpFile
+	CheckBase
+	File   []FtpFile
+	Upload string // directory to write a file to and read it back from
+}
+
+func init() {
+	Register("ftp", Definition{Check: Ftp{}, Port: 21})
 }
 
 type FtpFile struct {
@@ -21,7 +29,7 @@ type FtpFile struct {
 }
 
 func (c Ftp) Run(teamID uint, boxIp string, res chan Result) {
-	conn, err := ftp.Dial(boxIp+":"+strconv.Itoa(c.Port), ftp.DialWithTimeout(GlobalTimeout))
+	conn, err := ftp.Dial(net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), ftp.DialWithTimeout(c.FetchTimeout()))
 	if err != nil {
 		res <- Result{
 			Error: "ftp connection failed",
@@ -36,7 +44,7 @@ func (c Ftp) Run(teamID uint, boxIp string, res chan Result) {
 		username = "anonymous"
 		password = "anonymous"
 	} else {
-		username, password = getCreds(teamID, c.CredLists, c.Name)
+		username, password = GetCreds(teamID, c.CredLists, c.Name)
 	}
 	err = conn.Login(username, password)
 	if err != nil {
@@ -47,6 +55,48 @@ func (c Ftp) Run(teamID uint, boxIp string, res chan Result) {
 		return
 	}
 
+	if c.Upload != "" {
+		name, content := uploadFile(c.Upload)
+		err = conn.Stor(name, bytes.NewReader(content))
+		if err != nil {
+			res <- Result{
+				Error: "failed to upload file",
+				Debug: "tried to write " + name + " with creds " + username + ":" + password + ", error " + err.Error(),
+			}
+			return
+		}
+
+		// Read it back before cleaning up
+		r, err := conn.Retr(name)
+		var buf []byte
+		if err == nil {
+			buf, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+		delErr := conn.Delete(name)
+		if err != nil {
+			res <- Result{
+				Error: "failed to read back uploaded file",
+				Debug: "tried to read " + name + " with creds " + username + ":" + password + ", error " + err.Error(),
+			}
+			return
+		}
+		if !bytes.Equal(buf, content) {
+			res <- Result{
+				Error: "uploaded file content did not match",
+				Debug: "wrote " + strconv.Itoa(len(content)) + " bytes to " + name + ", read back " + strconv.Itoa(len(buf)),
+			}
+			return
+		}
+		if delErr != nil {
+			res <- Result{
+				Error: "failed to delete uploaded file",
+				Debug: "tried to delete " + name + " with creds " + username + ":" + password + ", error " + delErr.Error(),
+			}
+			return
+		}
+	}
+
 	if len(c.File) > 0 {
 		file := c.File[rand.Intn(len(c.File))]
 		r, err := conn.Retr(file.Name)
@@ -106,3 +156,12 @@ func (c Ftp) Run(teamID uint, boxIp string, res chan Result) {
 		Debug:  "creds used were " + username + ":" + password,
 	}
 }
+
+func (c *Ftp) Validate() error {
+	for _, f := range c.File {
+		if f.Regex != "" && f.Hash != "" {
+			return errors.New("can't have both regex and hash for ftp file check")
+		}
+	}
+	return nil
+}
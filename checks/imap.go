@@ -2,22 +2,28 @@ package checks
 
 import (
 	"crypto/tls"
-	"fmt"
+	"errors"
 	"net"
+	"strconv"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 )
 
 type Imap struct {
-	checkBase
+	CheckBase
 	Encrypted bool
+	TlsPolicy string
+}
+
+func init() {
+	Register("imap", Definition{Check: Imap{}, Port: 143})
 }
 
 func (c Imap) Run(teamID uint, boxIp string, res chan Result) {
 	// Create a dialer so we can set timeouts
 	dialer := net.Dialer{
-		Timeout: GlobalTimeout,
+		Timeout: c.FetchTimeout(),
 	}
 
 	// Defining these allow the if/else block below
@@ -26,9 +32,14 @@ func (c Imap) Run(teamID uint, boxIp string, res chan Result) {
 
 	// Connect to server with TLS or not
 	if c.Encrypted {
-		cl, err = client.DialWithDialerTLS(&dialer, fmt.Sprintf("%s:%d", boxIp, c.Port), &tls.Config{})
+		// Without a policy, certificates are verified as usual
+		tlsConfig := &tls.Config{}
+		if c.TlsPolicy != "" {
+			tlsConfig = tlsPolicyConfig(c.TlsPolicy)
+		}
+		cl, err = client.DialWithDialerTLS(&dialer, net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), tlsConfig)
 	} else {
-		cl, err = client.DialWithDialer(&dialer, fmt.Sprintf("%s:%s", boxIp, c.Port))
+		cl, err = client.DialWithDialer(&dialer, net.JoinHostPort(boxIp, strconv.Itoa(c.Port)))
 	}
 	if err != nil {
 		res <- Result{
@@ -40,9 +51,9 @@ func (c Imap) Run(teamID uint, boxIp string, res chan Result) {
 	defer cl.Close()
 
 	if !c.Anonymous {
-		username, password := getCreds(teamID, c.CredLists, c.Name)
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
 		// Set timeout for commands
-		cl.Timeout = GlobalTimeout
+		cl.Timeout = c.FetchTimeout()
 
 		// Login
 		err = cl.Login(username, password)
@@ -75,3 +86,15 @@ func (c Imap) Run(teamID uint, boxIp string, res chan Result) {
 		Debug:  "smtp server responded to request (anonymous)",
 	}
 }
+
+func (c *Imap) Validate() error {
+	if c.TlsPolicy != "" {
+		if !c.Encrypted {
+			return errors.New("tls policy needs encrypted imap for " + c.Name)
+		}
+		if err := checkTlsPolicy(c.TlsPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package checks
 
 import (
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -8,7 +10,7 @@ import (
 )
 
 type Irc struct {
-	checkBase
+	CheckBase
 	Command     []string
 	Channel     string
 	Stringmatch string
@@ -16,6 +18,10 @@ type Irc struct {
 	Msgcode     string
 }
 
+func init() {
+	Register("irc", Definition{Check: Irc{}, Port: 6667})
+}
+
 func (c Irc) Run(teamID uint, boxIp string, res chan Result) {
 
 	irc := client.SimpleClient(c.Nick)
@@ -28,7 +34,7 @@ func (c Irc) Run(teamID uint, boxIp string, res chan Result) {
 	})
 
 	// Connect to an IRC server.
-	if err := irc.ConnectTo(boxIp); err != nil {
+	if err := irc.ConnectTo(net.JoinHostPort(boxIp, strconv.Itoa(c.Port))); err != nil {
 		res <- Result{
 			Error: "IRC connection failed",
 			Debug: "IRC connection failed: " + err.Error(),
@@ -0,0 +1,116 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+)
+
+type Kerberos struct {
+	CheckBase
+	Realm string
+	Spn   string
+}
+
+func init() {
+	Register("kerberos", Definition{Check: Kerberos{}, Port: 88})
+}
+
+// krb5Conf is filled in with the realm and KDC address. DNS lookups
+// are disabled so the team's KDC is always the one asked, and TCP is
+// preferred since tickets for domain users are often too big for UDP.
+const krb5Conf = `[libdefaults]
+ default_realm = %[1]s
+ dns_lookup_kdc = false
+ dns_lookup_realm = false
+ udp_preference_limit = 1
+ default_tkt_enctypes = aes256-cts-hmac-sha1-96 aes128-cts-hmac-sha1-96 rc4-hmac
+ default_tgs_enctypes = aes256-cts-hmac-sha1-96 aes128-cts-hmac-sha1-96 rc4-hmac
+ permitted_enctypes = aes256-cts-hmac-sha1-96 aes128-cts-hmac-sha1-96 rc4-hmac
+
+[realms]
+ %[1]s = {
+  kdc = %[2]s
+ }
+`
+
+func (c Kerberos) Run(teamID uint, boxIp string, res chan Result) {
+	deadline := c.deadline()
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
+
+	cfg, err := config.NewFromString(fmt.Sprintf(krb5Conf, c.Realm, net.JoinHostPort(boxIp, strconv.Itoa(c.Port))))
+	if err != nil {
+		res <- Result{
+			Error: "error creating kerberos config",
+			Debug: err.Error(),
+		}
+		return
+	}
+
+	// gokrb5 can't be given a timeout (it waits up to 5 seconds on
+	// each KDC connection), so stop waiting on it at the deadline.
+	done := make(chan Result, 1)
+	go func() {
+		done <- c.exchange(username, password, cfg)
+	}()
+	select {
+	case result := <-done:
+		res <- result
+	case <-time.After(time.Until(deadline)):
+		res <- Result{
+			Error: "kerberos exchange timed out",
+			Debug: "no answer from the KDC before the check timed out, creds " + username + ":" + password,
+		}
+	}
+}
+
+// exchange requests a TGT, and a service ticket if there's an spn.
+func (c Kerberos) exchange(username, password string, cfg *config.Config) Result {
+	// Request a TGT (AS-REQ)
+	cl := client.NewWithPassword(username, c.Realm, password, cfg, client.DisablePAFXFAST(true))
+	defer cl.Destroy()
+	err := cl.Login()
+	if err != nil {
+		return Result{
+			Error: "failed to get TGT for " + username + "@" + c.Realm,
+			Debug: "creds " + username + ":" + password + ", error: " + err.Error(),
+		}
+	}
+
+	// Request a service ticket (TGS-REQ) if specified
+	if c.Spn != "" {
+		_, _, err = cl.GetServiceTicket(c.Spn)
+		if err != nil {
+			return Result{
+				Error: "failed to get service ticket for " + c.Spn,
+				Debug: "creds " + username + ":" + password + ", error: " + err.Error(),
+			}
+		}
+		return Result{
+			Status: true,
+			Debug:  "got TGT and service ticket for " + c.Spn + " with creds " + username + ":" + password,
+		}
+	}
+
+	return Result{
+		Status: true,
+		Debug:  "got TGT with creds " + username + ":" + password,
+	}
+}
+
+func (c *Kerberos) Validate() error {
+	if c.Realm == "" {
+		return errors.New("kerberos check " + c.Name + " needs a realm")
+	}
+	c.Realm = strings.ToUpper(c.Realm)
+	if c.Anonymous {
+		return errors.New("anonymous kerberos not supported")
+	}
+	return nil
+}
@@ -1,28 +1,48 @@
 package checks
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
 
 	ldap "github.com/go-ldap/ldap/v3"
 )
 
 type Ldap struct {
-	checkBase
+	CheckBase
 	Domain    string
 	Encrypted bool
+	StartTls  bool
+	Query     []ldapQuery
 }
 
-func (c Ldap) Run(teamID uint, boxIp string, res chan Result) {
-	// Set timeout
-	ldap.DefaultTimeout = GlobalTimeout
+func init() {
+	Register("ldap", Definition{Check: Ldap{}})
+}
 
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+type ldapQuery struct {
+	BaseDn     string
+	Filter     string
+	Attributes []string
+	UseRegex   bool
+	Output     string
+}
+
+func (c Ldap) Run(teamID uint, boxIp string, res chan Result) {
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
 	scheme := "ldap"
 	if c.Encrypted {
 		scheme = "ldaps"
 	}
-	lconn, err := ldap.DialURL(fmt.Sprintf("%s://%s:%d", scheme, boxIp, c.Port))
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	lconn, err := ldap.DialURL(fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(boxIp, strconv.Itoa(c.Port))), ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(&net.Dialer{Timeout: c.FetchTimeout()}))
 	if err != nil {
 		res <- Result{
 			Error: "failed to connect",
@@ -33,7 +53,18 @@ func (c Ldap) Run(teamID uint, boxIp string, res chan Result) {
 	defer lconn.Close()
 
 	// Set message timeout
-	lconn.SetTimeout(GlobalTimeout)
+	lconn.SetTimeout(c.FetchTimeout())
+
+	if c.StartTls {
+		err = lconn.StartTLS(tlsConfig)
+		if err != nil {
+			res <- Result{
+				Error: "starttls failed",
+				Debug: err.Error(),
+			}
+			return
+		}
+	}
 
 	// Attempt to login
 	splitDomain := strings.Split(c.Domain, ".")
@@ -55,8 +86,104 @@ func (c Ldap) Run(teamID uint, boxIp string, res chan Result) {
 		return
 	}
 
+	// If any queries specified, run a random one as the bound user
+	if len(c.Query) > 0 {
+		q := c.Query[rand.Intn(len(c.Query))]
+		baseDn := q.BaseDn
+		if baseDn == "" {
+			baseDn = "DC=" + strings.Join(splitDomain, ",DC=")
+		}
+
+		searchRequest := ldap.NewSearchRequest(
+			baseDn,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(c.FetchTimeout().Seconds()), false,
+			q.Filter,
+			q.Attributes,
+			nil,
+		)
+		result, err := lconn.Search(searchRequest)
+		if err != nil {
+			res <- Result{
+				Error: "search failed",
+				Debug: "base " + baseDn + " filter " + q.Filter + " as " + username + " failed with error: " + err.Error(),
+			}
+			return
+		}
+
+		if len(result.Entries) == 0 {
+			res <- Result{
+				Error: "search returned no entries",
+				Debug: "base " + baseDn + " filter " + q.Filter + " as " + username,
+			}
+			return
+		}
+
+		if q.Output != "" {
+			if !q.matches(result.Entries) {
+				res <- Result{
+					Error: "search results didn't contain value",
+					Debug: "base " + baseDn + " filter " + q.Filter + " attributes " + fmt.Sprint(q.Attributes) + " returned " + strconv.Itoa(len(result.Entries)) + " entries without " + q.Output,
+				}
+				return
+			}
+		}
+
+		res <- Result{
+			Status: true,
+			Debug:  "search for " + q.Filter + " returned " + strconv.Itoa(len(result.Entries)) + " entries as username " + username + " password " + password,
+		}
+		return
+	}
+
 	res <- Result{
 		Status: true,
 		Debug:  "login successful for username " + username + " password " + password,
 	}
 }
+
+// matches reports whether any value of any entry is the
+// query's output, or matches it if it's a regex.
+func (q ldapQuery) matches(entries []*ldap.Entry) bool {
+	var re *regexp.Regexp
+	if q.UseRegex {
+		re = regexp.MustCompile(q.Output)
+	}
+	for _, entry := range entries {
+		for _, attr := range entry.Attributes {
+			for _, value := range attr.Values {
+				if re != nil && re.MatchString(value) {
+					return true
+				}
+				if re == nil && strings.TrimSpace(value) == q.Output {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c *Ldap) Validate() error {
+	if c.Encrypted && c.StartTls {
+		return errors.New("cannot use both encrypted and starttls for ldap")
+	}
+	if c.Port == 0 {
+		if c.StartTls {
+			c.Port = 389
+		} else {
+			c.Port = 636
+		}
+	}
+	if c.Anonymous {
+		return errors.New("anonymous ldap not supported")
+	}
+	for k, q := range c.Query {
+		if q.Filter == "" {
+			c.Query[k].Filter = "(objectClass=*)"
+		}
+		if q.UseRegex {
+			regexp.MustCompile(q.Output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package checks
+
+import (
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestLdapQueryMatches(t *testing.T) {
+	entries := []*ldap.Entry{
+		ldap.NewEntry("CN=Robin Hood,CN=Users,DC=sherwood,DC=lan", map[string][]string{
+			"sAMAccountName": {"robin"},
+			"memberOf":       {"CN=Outlaws,CN=Users,DC=sherwood,DC=lan", "CN=Archers,CN=Users,DC=sherwood,DC=lan"},
+		}),
+		ldap.NewEntry("CN=Little John,CN=Users,DC=sherwood,DC=lan", map[string][]string{
+			"sAMAccountName": {"john "},
+		}),
+	}
+
+	tests := []struct {
+		name  string
+		query ldapQuery
+		want  bool
+	}{
+		{"exact value", ldapQuery{Output: "robin"}, true},
+		{"trailing space trimmed", ldapQuery{Output: "john"}, true},
+		{"later value", ldapQuery{Output: "CN=Archers,CN=Users,DC=sherwood,DC=lan"}, true},
+		{"substring isn't exact", ldapQuery{Output: "rob"}, false},
+		{"missing", ldapQuery{Output: "tuck"}, false},
+		{"regex", ldapQuery{Output: "^CN=Archers,", UseRegex: true}, true},
+		{"regex no match", ldapQuery{Output: "^CN=Friars,", UseRegex: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.matches(entries); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if (ldapQuery{Output: "robin"}).matches(nil) {
+		t.Error("matched with no entries")
+	}
+}
+
+func TestLdapValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		check Ldap
+		port  int
+		err   bool
+	}{
+		{"ldaps port", Ldap{}, 636, false},
+		{"starttls port", Ldap{StartTls: true}, 389, false},
+		{"port kept", Ldap{CheckBase: CheckBase{Port: 3269}, Encrypted: true}, 3269, false},
+		{"both tls options", Ldap{Encrypted: true, StartTls: true}, 0, true},
+		{"anonymous", Ldap{CheckBase: CheckBase{Anonymous: true}}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check.Validate()
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !tt.err && tt.check.Port != tt.port {
+				t.Errorf("port %d, want %d", tt.check.Port, tt.port)
+			}
+		})
+	}
+
+	// Queries without a filter match everything
+	c := Ldap{Query: []ldapQuery{{}, {Filter: "(sAMAccountName=robin)"}}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Query[0].Filter != "(objectClass=*)" || c.Query[1].Filter != "(sAMAccountName=robin)" {
+		t.Errorf("filters %q, %q", c.Query[0].Filter, c.Query[1].Filter)
+	}
+}
@@ -0,0 +1,279 @@
+package checks
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/google/uuid"
+)
+
+// Mail sends a message with a unique token through the team's
+// SMTP server, then logs in as the recipient over IMAP or POP3
+// and waits for the token to show up.
+type Mail struct {
+	CheckBase
+	Domain            string
+	Sender            string
+	SenderCredLists   []string
+	Encrypted         bool
+	Protocol          string
+	RetrievePort      int
+	RetrieveEncrypted bool
+}
+
+func init() {
+	Register("mail", Definition{Check: Mail{}})
+}
+
+// mailPollInterval is how long to wait between mailbox checks
+// while waiting for the message to be delivered.
+const mailPollInterval = 2 * time.Second
+
+func (c Mail) Run(teamID uint, boxIp string, res chan Result) {
+	start := time.Now()
+	deadline := c.deadline()
+	token := uuid.New().String()
+
+	// Recipient creds are used to retrieve the mail
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
+	receiver := c.address(username)
+
+	// Sender creds are only used if the sender needs to log in
+	var auth smtp.Auth
+	sender := c.Sender
+	senderDebug := ""
+	if len(c.SenderCredLists) > 0 {
+		senderUsername, senderPassword := GetCreds(teamID, c.SenderCredLists, c.Name)
+		auth = unencryptedAuth{smtp.PlainAuth("", senderUsername, senderPassword, boxIp)}
+		sender = c.address(senderUsername)
+		senderDebug = " with sender creds " + senderUsername + ":" + senderPassword
+	}
+
+	body := "From: " + sender + "\r\n" +
+		"To: " + receiver + "\r\n" +
+		"Subject: Scoring check " + token + "\r\n" +
+		"\r\n" +
+		"Mail delivery token: " + token + "\r\n"
+
+	err := sendMail(net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), boxIp, c.Encrypted, auth, sender, receiver, body, c.FetchTimeout())
+	if err != nil {
+		res <- Result{
+			Error: "sending mail failed",
+			Debug: "from " + sender + " to " + receiver + senderDebug + ": " + err.Error(),
+		}
+		return
+	}
+
+	retrieveAddr := net.JoinHostPort(boxIp, strconv.Itoa(c.RetrievePort))
+	attempts := 0
+	for {
+		attempts++
+		var found bool
+		if c.Protocol == "pop3" {
+			found, err = findPop3Token(retrieveAddr, c.RetrieveEncrypted, username, password, token, c.FetchTimeout())
+		} else {
+			found, err = findImapToken(retrieveAddr, c.RetrieveEncrypted, username, password, token, c.FetchTimeout())
+		}
+		if err != nil {
+			res <- Result{
+				Error: c.Protocol + " retrieval failed",
+				Debug: "creds " + username + ":" + password + ", error: " + err.Error(),
+			}
+			return
+		}
+		if found {
+			break
+		}
+		if time.Now().Add(mailPollInterval).After(deadline) {
+			res <- Result{
+				Error: "mail was not delivered in time",
+				Debug: "token " + token + " sent from " + sender + " to " + receiver + " never arrived after " + strconv.Itoa(attempts) + " " + c.Protocol + " checks",
+			}
+			return
+		}
+		time.Sleep(mailPollInterval)
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  "token " + token + " delivered from " + sender + " to " + receiver + " in " + time.Since(start).Round(time.Millisecond).String() + ", retrieved with creds " + username + ":" + password,
+	}
+}
+
+// address turns a username into a mail address in the
+// check's domain, unless it already is one.
+func (c Mail) address(username string) string {
+	if strings.Contains(username, "@") {
+		return username
+	}
+	return username + "@" + c.Domain
+}
+
+// sendMail delivers body from sender to receiver, logging in with
+// auth first if it's not nil.
+func sendMail(addr, host string, encrypted bool, auth smtp.Auth, sender, receiver, body string, timeout time.Duration) error {
+	dialer := net.Dialer{
+		Timeout: timeout,
+	}
+
+	var conn net.Conn
+	var err error
+	if encrypted {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	sconn, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return errors.New("smtp client creation failed: " + err.Error())
+	}
+	defer sconn.Quit()
+
+	if auth != nil {
+		if err := sconn.Auth(auth); err != nil {
+			return errors.New("login failed: " + err.Error())
+		}
+	}
+	if err := sconn.Mail(sender); err != nil {
+		return errors.New("setting sender failed: " + err.Error())
+	}
+	if err := sconn.Rcpt(receiver); err != nil {
+		return errors.New("setting receiver failed: " + err.Error())
+	}
+	wc, err := sconn.Data()
+	if err != nil {
+		return errors.New("creating email writer failed: " + err.Error())
+	}
+	if _, err := fmt.Fprint(wc, body); err != nil {
+		return errors.New("writing body failed: " + err.Error())
+	}
+	if err := wc.Close(); err != nil {
+		return errors.New("message was not accepted: " + err.Error())
+	}
+	return nil
+}
+
+// findImapToken logs in to the INBOX and searches for a message
+// containing token. Found messages are deleted so mailboxes don't
+// fill up with check mail.
+func findImapToken(addr string, encrypted bool, username, password, token string, timeout time.Duration) (bool, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+	}
+
+	var cl *client.Client
+	var err error
+	if encrypted {
+		cl, err = client.DialWithDialerTLS(&dialer, addr, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		cl, err = client.DialWithDialer(&dialer, addr)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer cl.Close()
+	cl.Timeout = timeout
+
+	if err := cl.Login(username, password); err != nil {
+		return false, errors.New("login failed: " + err.Error())
+	}
+	defer cl.Logout()
+
+	if _, err := cl.Select("INBOX", false); err != nil {
+		return false, errors.New("selecting inbox failed: " + err.Error())
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Text = []string{token}
+	uids, err := cl.UidSearch(criteria)
+	if err != nil {
+		return false, errors.New("searching inbox failed: " + err.Error())
+	}
+	if len(uids) == 0 {
+		return false, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	if err := cl.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err == nil {
+		cl.Expunge(nil)
+	}
+	return true, nil
+}
+
+// findPop3Token is the POP3 version of findImapToken.
+func findPop3Token(addr string, encrypted bool, username, password, token string, timeout time.Duration) (bool, error) {
+	cl, err := dialPop3(addr, encrypted, false, timeout)
+	if err != nil {
+		return false, err
+	}
+	defer cl.quit()
+
+	if err := cl.login(username, password); err != nil {
+		return false, errors.New("login failed: " + err.Error())
+	}
+
+	count, err := cl.stat()
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := cl.search(token, count)
+	if err != nil || msg == 0 {
+		return false, err
+	}
+	cl.cmd("DELE %d", msg)
+	return true, nil
+}
+
+func (c *Mail) Validate() error {
+	if c.Domain == "" {
+		return errors.New("mail check " + c.Name + " needs a domain")
+	}
+	if c.Sender == "" {
+		c.Sender = "scoring@" + c.Domain
+	}
+	if c.Port == 0 {
+		if c.Encrypted {
+			c.Port = 465
+		} else {
+			c.Port = 25
+		}
+	}
+	if c.Protocol == "" {
+		c.Protocol = "imap"
+	}
+	if c.RetrievePort == 0 {
+		switch {
+		case c.Protocol == "imap" && c.RetrieveEncrypted:
+			c.RetrievePort = 993
+		case c.Protocol == "imap":
+			c.RetrievePort = 143
+		case c.Protocol == "pop3" && c.RetrieveEncrypted:
+			c.RetrievePort = 995
+		case c.Protocol == "pop3":
+			c.RetrievePort = 110
+		}
+	}
+	if c.Protocol != "imap" && c.Protocol != "pop3" {
+		return errors.New("mail protocol must be imap or pop3 for " + c.Name)
+	}
+	if c.Anonymous {
+		return errors.New("anonymous mail not supported")
+	}
+	return nil
+}
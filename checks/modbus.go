@@ -0,0 +1,193 @@
+package checks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goburrow/modbus"
+)
+
+type Modbus struct {
+	CheckBase
+	UnitID   *int // pointer so an explicit 0 isn't replaced by the default
+	Register []modbusData
+}
+
+func init() {
+	Register("modbus", Definition{Check: Modbus{}, Port: 502, Anonymous: true})
+}
+
+type modbusData struct {
+	Kind     string // coil, discrete, holding, or input
+	Address  int
+	Quantity int
+	Min      int
+	Max      int
+	Regex    string
+	Write    bool
+	Value    int
+}
+
+func (c Modbus) Run(teamID uint, boxIp string, res chan Result) {
+	r := c.Register[rand.Intn(len(c.Register))]
+
+	handler := modbus.NewTCPClientHandler(net.JoinHostPort(boxIp, strconv.Itoa(c.Port)))
+	handler.Timeout = c.FetchTimeout()
+	handler.SlaveId = byte(*c.UnitID)
+	err := handler.Connect()
+	if err != nil {
+		res <- Result{
+			Error: "connection to modbus server failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	// Write the value first, so the read below verifies it stuck
+	if r.Write {
+		switch r.Kind {
+		case "coil":
+			value := uint16(0x0000)
+			if r.Value != 0 {
+				value = 0xFF00
+			}
+			_, err = client.WriteSingleCoil(uint16(r.Address), value)
+		case "holding":
+			_, err = client.WriteSingleRegister(uint16(r.Address), uint16(r.Value))
+		}
+		if err != nil {
+			res <- Result{
+				Error: "failed to write " + r.Kind + " " + strconv.Itoa(r.Address),
+				Debug: err.Error(),
+			}
+			return
+		}
+	}
+
+	var raw []byte
+	switch r.Kind {
+	case "coil":
+		raw, err = client.ReadCoils(uint16(r.Address), uint16(r.Quantity))
+	case "discrete":
+		raw, err = client.ReadDiscreteInputs(uint16(r.Address), uint16(r.Quantity))
+	case "holding":
+		raw, err = client.ReadHoldingRegisters(uint16(r.Address), uint16(r.Quantity))
+	case "input":
+		raw, err = client.ReadInputRegisters(uint16(r.Address), uint16(r.Quantity))
+	}
+	if err != nil {
+		res <- Result{
+			Error: "failed to read " + r.Kind + " " + strconv.Itoa(r.Address),
+			Debug: err.Error(),
+		}
+		return
+	}
+
+	values := modbusValues(r.Kind, raw, r.Quantity)
+	out := strings.Trim(fmt.Sprint(values), "[]")
+	debug := "read " + r.Kind + " " + strconv.Itoa(r.Address) + " (quantity " + strconv.Itoa(r.Quantity) + "): " + out
+
+	if r.Write {
+		for _, v := range values {
+			if (r.Kind == "coil" && (v != 0) != (r.Value != 0)) || (r.Kind == "holding" && v != r.Value) {
+				res <- Result{
+					Error: "value read back didn't match value written",
+					Debug: debug + ", wrote " + strconv.Itoa(r.Value),
+				}
+				return
+			}
+		}
+	}
+
+	if r.Min != 0 || r.Max != 0 {
+		for _, v := range values {
+			if v < r.Min || v > r.Max {
+				res <- Result{
+					Error: "value out of expected range",
+					Debug: debug + ", wanted between " + strconv.Itoa(r.Min) + " and " + strconv.Itoa(r.Max),
+				}
+				return
+			}
+		}
+	}
+
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			res <- Result{
+				Error: "error compiling regex to match for modbus values",
+				Debug: err.Error(),
+			}
+			return
+		}
+		if !re.MatchString(out) {
+			res <- Result{
+				Error: "values didn't match regex",
+				Debug: debug + ", couldn't find regex \"" + r.Regex + "\"",
+			}
+			return
+		}
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  debug,
+	}
+}
+
+// modbusValues unpacks a modbus response into one value per
+// coil or register. Coils and discrete inputs come back as
+// packed bits, registers as big endian words.
+func modbusValues(kind string, raw []byte, quantity int) []int {
+	values := []int{}
+	switch kind {
+	case "coil", "discrete":
+		for i := 0; i < quantity && i/8 < len(raw); i++ {
+			values = append(values, int(raw[i/8]>>(uint(i)%8)&1))
+		}
+	default:
+		for i := 0; i+1 < len(raw); i += 2 {
+			values = append(values, int(binary.BigEndian.Uint16(raw[i:])))
+		}
+	}
+	return values
+}
+
+func (c *Modbus) Validate() error {
+	if c.UnitID == nil {
+		unitID := 1
+		c.UnitID = &unitID
+	}
+	if *c.UnitID < 0 || *c.UnitID > 255 {
+		return errors.New("modbus unit id for " + c.Name + " must be between 0 and 255")
+	}
+	if len(c.Register) < 1 {
+		return errors.New("modbus check " + c.Name + " has no registers")
+	}
+	for k, r := range c.Register {
+		if r.Kind != "coil" && r.Kind != "discrete" && r.Kind != "holding" && r.Kind != "input" {
+			return errors.New("invalid modbus register kind for " + c.Name + ": " + r.Kind)
+		}
+		if r.Write && r.Kind != "coil" && r.Kind != "holding" {
+			return errors.New("can only write to coils and holding registers for modbus")
+		}
+		if r.Min > r.Max {
+			return errors.New("modbus min can't be larger than max")
+		}
+		if r.Regex != "" {
+			regexp.MustCompile(r.Regex)
+		}
+		if r.Quantity == 0 {
+			c.Register[k].Quantity = 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package checks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModbusValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		raw      []byte
+		quantity int
+		want     []int
+	}{
+		{"one coil on", "coil", []byte{0x01}, 1, []int{1}},
+		{"coils low bit first", "coil", []byte{0x05}, 4, []int{1, 0, 1, 0}},
+		{"coils across bytes", "discrete", []byte{0x80, 0x01}, 9, []int{0, 0, 0, 0, 0, 0, 0, 1, 1}},
+		{"padding bits ignored", "coil", []byte{0xff}, 3, []int{1, 1, 1}},
+		{"short coil response", "coil", []byte{0x03}, 10, []int{1, 1, 0, 0, 0, 0, 0, 0}},
+		{"holding register", "holding", []byte{0x01, 0x2c}, 1, []int{300}},
+		{"input registers big endian", "input", []byte{0x00, 0x07, 0xff, 0xff}, 2, []int{7, 65535}},
+		{"odd register byte dropped", "holding", []byte{0x00, 0x07, 0x01}, 2, []int{7}},
+		{"empty", "holding", nil, 1, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := modbusValues(tt.kind, tt.raw, tt.quantity)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModbusValidate(t *testing.T) {
+	zero, big := 0, 256
+	tests := []struct {
+		name   string
+		check  Modbus
+		unitID int
+		err    bool
+	}{
+		{"default unit id", Modbus{Register: []modbusData{{Kind: "coil"}}}, 1, false},
+		{"unit id 0 kept", Modbus{UnitID: &zero, Register: []modbusData{{Kind: "holding"}}}, 0, false},
+		{"unit id too big", Modbus{UnitID: &big, Register: []modbusData{{Kind: "holding"}}}, 0, true},
+		{"no registers", Modbus{}, 0, true},
+		{"bad kind", Modbus{Register: []modbusData{{Kind: "analog"}}}, 0, true},
+		{"write to input", Modbus{Register: []modbusData{{Kind: "input", Write: true}}}, 0, true},
+		{"min over max", Modbus{Register: []modbusData{{Kind: "holding", Min: 5, Max: 1}}}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check.Validate()
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *tt.check.UnitID != tt.unitID {
+				t.Errorf("unit id %d, want %d", *tt.check.UnitID, tt.unitID)
+			}
+			if tt.check.Register[0].Quantity != 1 {
+				t.Errorf("quantity %d, want 1", tt.check.Register[0].Quantity)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package checks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// A minimal NTLMv2 client (MS-NLMP), enough to authenticate and seal
+// messages for CredSSP. It always uses extended session security,
+// 128-bit keys, and key exchange.
+
+const (
+	ntlmNegotiateUnicode         = 0x00000001
+	ntlmRequestTarget            = 0x00000004
+	ntlmNegotiateSign            = 0x00000010
+	ntlmNegotiateSeal            = 0x00000020
+	ntlmNegotiateNTLM            = 0x00000200
+	ntlmNegotiateAlwaysSign      = 0x00008000
+	ntlmNegotiateExtendedSession = 0x00080000
+	ntlmNegotiateTargetInfo      = 0x00800000
+	ntlmNegotiateVersion         = 0x02000000
+	ntlmNegotiate128             = 0x20000000
+	ntlmNegotiateKeyExch         = 0x40000000
+	ntlmNegotiate56              = 0x80000000
+
+	ntlmAvEOL       = 0
+	ntlmAvFlags     = 6
+	ntlmAvTimestamp = 7
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmVersion is sent in messages when NEGOTIATE_VERSION is set
+// (Windows 10, NTLM revision 15).
+var ntlmVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15}
+
+type ntlmClient struct {
+	username string
+	password string
+	domain   string
+
+	negotiateMsg []byte
+
+	sessionKey []byte
+	clientSign []byte
+	serverSign []byte
+	clientSeal *rc4.Cipher
+	serverSeal *rc4.Cipher
+	sendSeqNum uint32
+	recvSeqNum uint32
+}
+
+func newNtlmClient(username, password, domain string) *ntlmClient {
+	// Accept DOMAIN\user and user@domain too
+	if i := strings.Index(username, "\\"); i != -1 {
+		domain = username[:i]
+		username = username[i+1:]
+	} else if i := strings.Index(username, "@"); i != -1 && domain == "" {
+		domain = username[i+1:]
+		username = username[:i]
+	}
+	return &ntlmClient{
+		username: username,
+		password: password,
+		domain:   domain,
+	}
+}
+
+// negotiate returns the NEGOTIATE_MESSAGE.
+func (n *ntlmClient) negotiate() []byte {
+	flags := uint32(ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign | ntlmNegotiateSeal |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSession |
+		ntlmNegotiateTargetInfo | ntlmNegotiateVersion | ntlmNegotiate128 | ntlmNegotiateKeyExch | ntlmNegotiate56)
+
+	msg := make([]byte, 40)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], flags)
+	// Domain and workstation fields are empty, pointing at the end
+	binary.LittleEndian.PutUint32(msg[20:], 40)
+	binary.LittleEndian.PutUint32(msg[28:], 40)
+	copy(msg[32:], ntlmVersion)
+	n.negotiateMsg = msg
+	return msg
+}
+
+// authenticate processes the CHALLENGE_MESSAGE and returns the
+// AUTHENTICATE_MESSAGE. Afterwards, messages can be sealed.
+func (n *ntlmClient) authenticate(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("invalid ntlm challenge message")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+	targetInfo, err := ntlmField(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+	if flags&ntlmNegotiateExtendedSession == 0 || flags&ntlmNegotiateKeyExch == 0 || flags&ntlmNegotiate128 == 0 {
+		return nil, errors.New("server does not support ntlm extended session security with key exchange")
+	}
+
+	// Use the server's timestamp if it sent one, and tell it
+	// we're providing a MIC
+	timestamp := make([]byte, 8)
+	avPairs, hasTimestamp := ntlmParseAvPairs(targetInfo)
+	if ts, ok := avPairs[ntlmAvTimestamp]; ok {
+		copy(timestamp, ts)
+	} else {
+		// FILETIME is 100ns intervals since 1601
+		binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()/100+116444736000000000))
+	}
+	avFlags := uint32(0)
+	if f, ok := avPairs[ntlmAvFlags]; ok && len(f) == 4 {
+		avFlags = binary.LittleEndian.Uint32(f)
+	}
+	if hasTimestamp {
+		avFlags |= 0x2
+	}
+	targetInfo = ntlmSetAvFlags(targetInfo, avFlags)
+
+	clientChallenge := make([]byte, 8)
+	rand.Read(clientChallenge)
+
+	responseKey := ntowfv2(n.password, n.username, n.domain)
+	ntProof, ntResponse := ntlmV2Response(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo)
+	lmResponse := make([]byte, 24)
+	if !hasTimestamp {
+		lmResponse = lmV2Response(responseKey, serverChallenge, clientChallenge)
+	}
+
+	// With key exchange, the exported session key is random and
+	// sent encrypted with the key exchange key
+	keyExchangeKey := hmacMd5(responseKey, ntProof)
+	sessionKey := make([]byte, 16)
+	rand.Read(sessionKey)
+	encryptedKey := ntlmEncryptKey(keyExchangeKey, sessionKey)
+	n.setSessionKey(sessionKey)
+
+	domain := utf16le(n.domain)
+	user := utf16le(n.username)
+	workstation := []byte{}
+
+	const headerLen = 88
+	msg := make([]byte, headerLen)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	payload := []byte{}
+	for i, field := range [][]byte{lmResponse, ntResponse, domain, user, workstation, encryptedKey} {
+		offset := headerLen + len(payload)
+		binary.LittleEndian.PutUint16(msg[12+i*8:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(msg[14+i*8:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(msg[16+i*8:], uint32(offset))
+		payload = append(payload, field...)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	copy(msg[64:], ntlmVersion)
+	msg = append(msg, payload...)
+
+	if hasTimestamp {
+		mic := hmac.New(md5.New, n.sessionKey)
+		mic.Write(n.negotiateMsg)
+		mic.Write(challenge)
+		mic.Write(msg)
+		copy(msg[72:], mic.Sum(nil))
+	}
+
+	return msg, nil
+}
+
+// setSessionKey sets the exported session key, and the signing
+// and sealing keys that come from it.
+func (n *ntlmClient) setSessionKey(sessionKey []byte) {
+	n.sessionKey = sessionKey
+	n.clientSign = ntlmKey(sessionKey, "session key to client-to-server signing key magic constant\x00")
+	n.serverSign = ntlmKey(sessionKey, "session key to server-to-client signing key magic constant\x00")
+	n.clientSeal, _ = rc4.NewCipher(ntlmKey(sessionKey, "session key to client-to-server sealing key magic constant\x00"))
+	n.serverSeal, _ = rc4.NewCipher(ntlmKey(sessionKey, "session key to server-to-client sealing key magic constant\x00"))
+	n.sendSeqNum = 0
+	n.recvSeqNum = 0
+}
+
+// ntowfv2 is the NTLMv2 response key for a user.
+func ntowfv2(password, username, domain string) []byte {
+	ntHash := md4.New()
+	ntHash.Write(utf16le(password))
+	return hmacMd5(ntHash.Sum(nil), utf16le(strings.ToUpper(username)+domain))
+}
+
+// ntlmV2Response returns the NTProofStr and the full NT response.
+func ntlmV2Response(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte) {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntProof := hmacMd5(responseKey, append(append([]byte{}, serverChallenge...), temp...))
+	return ntProof, append(append([]byte{}, ntProof...), temp...)
+}
+
+func lmV2Response(responseKey, serverChallenge, clientChallenge []byte) []byte {
+	proof := hmacMd5(responseKey, append(append([]byte{}, serverChallenge...), clientChallenge...))
+	return append(proof, clientChallenge...)
+}
+
+// ntlmEncryptKey encrypts the exported session key for key exchange.
+func ntlmEncryptKey(keyExchangeKey, sessionKey []byte) []byte {
+	encryptedKey := make([]byte, 16)
+	cipher, _ := rc4.NewCipher(keyExchangeKey)
+	cipher.XORKeyStream(encryptedKey, sessionKey)
+	return encryptedKey
+}
+
+// seal encrypts a message to the server, returning the
+// signature followed by the encrypted message.
+func (n *ntlmClient) seal(message []byte) []byte {
+	sealed := make([]byte, len(message))
+	n.clientSeal.XORKeyStream(sealed, message)
+	signature := ntlmMac(n.clientSign, n.clientSeal, n.sendSeqNum, message)
+	n.sendSeqNum++
+	return append(signature, sealed...)
+}
+
+// unseal decrypts a message from the server and checks
+// its signature.
+func (n *ntlmClient) unseal(message []byte) ([]byte, error) {
+	if len(message) < 16 {
+		return nil, errors.New("sealed message too short")
+	}
+	plain := make([]byte, len(message)-16)
+	n.serverSeal.XORKeyStream(plain, message[16:])
+	signature := ntlmMac(n.serverSign, n.serverSeal, n.recvSeqNum, plain)
+	n.recvSeqNum++
+	if !hmac.Equal(signature, message[:16]) {
+		return nil, errors.New("invalid signature on sealed message")
+	}
+	return plain, nil
+}
+
+func ntlmMac(signKey []byte, seal *rc4.Cipher, seqNum uint32, message []byte) []byte {
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, seqNum)
+	checksum := hmacMd5(signKey, append(seq, message...))[:8]
+	seal.XORKeyStream(checksum, checksum)
+	signature := []byte{1, 0, 0, 0}
+	signature = append(signature, checksum...)
+	return append(signature, seq...)
+}
+
+func ntlmKey(sessionKey []byte, magic string) []byte {
+	sum := md5.Sum(append(append([]byte{}, sessionKey...), magic...))
+	return sum[:]
+}
+
+// ntlmField returns the payload a length/offset field points to.
+func ntlmField(msg []byte, at int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(msg[at:]))
+	offset := int(binary.LittleEndian.Uint32(msg[at+4:]))
+	if offset+length > len(msg) {
+		return nil, errors.New("ntlm message field out of range")
+	}
+	return msg[offset : offset+length], nil
+}
+
+func ntlmParseAvPairs(info []byte) (map[uint16][]byte, bool) {
+	pairs := map[uint16][]byte{}
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == ntlmAvEOL || 4+length > len(info) {
+			break
+		}
+		pairs[id] = info[4 : 4+length]
+		info = info[4+length:]
+	}
+	_, hasTimestamp := pairs[ntlmAvTimestamp]
+	return pairs, hasTimestamp
+}
+
+// ntlmSetAvFlags rewrites the target info with MsvAvFlags set.
+func ntlmSetAvFlags(info []byte, avFlags uint32) []byte {
+	out := []byte{}
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == ntlmAvEOL || 4+length > len(info) {
+			break
+		}
+		if id != ntlmAvFlags {
+			out = append(out, info[:4+length]...)
+		}
+		info = info[4+length:]
+	}
+	if avFlags != 0 {
+		pair := make([]byte, 8)
+		binary.LittleEndian.PutUint16(pair, ntlmAvFlags)
+		binary.LittleEndian.PutUint16(pair[2:], 4)
+		binary.LittleEndian.PutUint32(pair[4:], avFlags)
+		out = append(out, pair...)
+	}
+	return append(out, 0, 0, 0, 0)
+}
+
+func hmacMd5(key, data []byte) []byte {
+	h := hmac.New(md5.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func utf16le(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, len(encoded)*2)
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[i*2:], r)
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package checks
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Known answers from MS-NLMP 4.2.4 (NTLMv2 authentication)
+var (
+	nlmpUser            = "User"
+	nlmpDomain          = "Domain"
+	nlmpPassword        = "Password"
+	nlmpServerChallenge = unhex("0123456789abcdef")
+	nlmpClientChallenge = unhex("aaaaaaaaaaaaaaaa")
+	nlmpRandomKey       = unhex("55555555555555555555555555555555")
+	nlmpTime            = unhex("0000000000000000")
+
+	// MsvAvNbDomainName "Domain", MsvAvNbComputerName "Server", MsvAvEOL
+	nlmpTargetInfo = unhex("02000c0044006f006d00610069006e0001000c00530065007200760065007200" + "00000000")
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestNtlmKnownAnswers(t *testing.T) {
+	responseKey := ntowfv2(nlmpPassword, nlmpUser, nlmpDomain)
+	ntProof, ntResponse := ntlmV2Response(responseKey, nlmpServerChallenge, nlmpClientChallenge, nlmpTime, nlmpTargetInfo)
+	sessionBaseKey := hmacMd5(responseKey, ntProof)
+
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"NTOWFv2", responseKey, "0c868a403bfd7a93a3001ef22ef02e3f"},
+		{"NTProofStr", ntProof, "68cd0ab851e51c96aabc927bebef6a1c"},
+		{"NTLMv2 response temp", ntResponse[16:], "0101000000000000" + "0000000000000000" + "aaaaaaaaaaaaaaaa" + "00000000" + hex.EncodeToString(nlmpTargetInfo) + "00000000"},
+		{"LMv2 response", lmV2Response(responseKey, nlmpServerChallenge, nlmpClientChallenge), "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"},
+		{"session base key", sessionBaseKey, "8de40ccadbc14a82f15cb0ad0de95ca3"},
+		{"encrypted session key", ntlmEncryptKey(sessionBaseKey, nlmpRandomKey), "c5dad2544fc9799094ce1ce90bc9d03e"},
+	}
+	for _, tt := range tests {
+		if hex.EncodeToString(tt.got) != tt.want {
+			t.Errorf("%s = %x, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestNtlmSeal(t *testing.T) {
+	// MS-NLMP 4.2.4.4, GSS_WrapEx with the random session key
+	n := newNtlmClient(nlmpUser, nlmpPassword, nlmpDomain)
+	n.setSessionKey(nlmpRandomKey)
+
+	if got := hex.EncodeToString(n.clientSign); got != "4788dc861b4782f35d43fd98fe1a2d39" {
+		t.Errorf("signing key = %s", got)
+	}
+	sealed := n.seal(utf16le("Plaintext"))
+	wantSignature := "010000007fb38ec5c55d497600000000"
+	wantData := "54e50165bf1936dc996020c1811b0f06fb5f"
+	if got := hex.EncodeToString(sealed[:16]); got != wantSignature {
+		t.Errorf("signature = %s, want %s", got, wantSignature)
+	}
+	if got := hex.EncodeToString(sealed[16:]); got != wantData {
+		t.Errorf("sealed data = %s, want %s", got, wantData)
+	}
+
+	// The server side of the same keys can read it back
+	server := newNtlmClient(nlmpUser, nlmpPassword, nlmpDomain)
+	server.setSessionKey(nlmpRandomKey)
+	server.serverSign, server.serverSeal = server.clientSign, server.clientSeal
+	plain, err := server.unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, utf16le("Plaintext")) {
+		t.Errorf("unsealed %x", plain)
+	}
+}
+
+func TestNtlmUsername(t *testing.T) {
+	tests := []struct {
+		username, domain     string
+		wantUser, wantDomain string
+	}{
+		{"robin", "SHERWOOD", "robin", "SHERWOOD"},
+		{`SHERWOOD\robin`, "", "robin", "SHERWOOD"},
+		{"robin@sherwood.lan", "", "robin", "sherwood.lan"},
+		{"robin@sherwood.lan", "SHERWOOD", "robin@sherwood.lan", "SHERWOOD"},
+	}
+	for _, tt := range tests {
+		n := newNtlmClient(tt.username, "pw", tt.domain)
+		if n.username != tt.wantUser || n.domain != tt.wantDomain {
+			t.Errorf("newNtlmClient(%q, %q) = %q, %q", tt.username, tt.domain, n.username, n.domain)
+		}
+	}
+}
+
+func TestNtlmAvPairs(t *testing.T) {
+	pairs, hasTimestamp := ntlmParseAvPairs(nlmpTargetInfo)
+	if hasTimestamp || string(pairs[2]) != string(utf16le("Domain")) || string(pairs[1]) != string(utf16le("Server")) {
+		t.Fatalf("parsed %v, timestamp %v", pairs, hasTimestamp)
+	}
+
+	// MsvAvFlags is added before the end, and replaced if already there
+	withFlags := ntlmSetAvFlags(nlmpTargetInfo, 2)
+	want := hex.EncodeToString(nlmpTargetInfo[:len(nlmpTargetInfo)-4]) + "0600040002000000" + "00000000"
+	if got := hex.EncodeToString(withFlags); got != want {
+		t.Errorf("ntlmSetAvFlags = %s, want %s", got, want)
+	}
+	if got := hex.EncodeToString(ntlmSetAvFlags(withFlags, 2)); got != want {
+		t.Errorf("ntlmSetAvFlags twice = %s, want %s", got, want)
+	}
+}
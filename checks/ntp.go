@@ -0,0 +1,86 @@
+package checks
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+type Ntp struct {
+	CheckBase
+	MaxOffset  int // milliseconds
+	MinStratum int
+	MaxStratum int
+}
+
+func init() {
+	Register("ntp", Definition{Check: Ntp{}, Port: 123, Anonymous: true})
+}
+
+func (c Ntp) Run(teamID uint, boxIp string, res chan Result) {
+	resp, err := ntp.QueryWithOptions(boxIp, ntp.QueryOptions{
+		Timeout: c.FetchTimeout(),
+		Port:    c.Port,
+	})
+	if err != nil {
+		res <- Result{
+			Error: "ntp query failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+
+	offset := resp.ClockOffset
+	if offset < 0 {
+		offset = -offset
+	}
+	stratum := int(resp.Stratum)
+	debug := "offset " + resp.ClockOffset.String() + ", stratum " + strconv.Itoa(stratum)
+
+	// Stratum 0 is a kiss of death, the server refusing to serve time
+	if resp.KissCode != "" {
+		debug += ", kiss code " + resp.KissCode
+	}
+	if stratum < c.MinStratum || stratum > c.MaxStratum {
+		res <- Result{
+			Error: "stratum " + strconv.Itoa(stratum) + " out of range",
+			Debug: debug + ", wanted stratum between " + strconv.Itoa(c.MinStratum) + " and " + strconv.Itoa(c.MaxStratum),
+		}
+		return
+	}
+
+	tolerance := time.Duration(c.MaxOffset) * time.Millisecond
+	if offset > tolerance {
+		res <- Result{
+			Error: "time offset too large",
+			Debug: debug + ", wanted offset within " + tolerance.String(),
+		}
+		return
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  debug,
+	}
+}
+
+func (c *Ntp) Validate() error {
+	if c.MaxOffset == 0 {
+		c.MaxOffset = 1000
+	}
+	if c.MinStratum == 0 {
+		c.MinStratum = 1
+	}
+	if c.MaxStratum == 0 {
+		c.MaxStratum = 15
+	}
+	if c.MaxOffset < 0 {
+		return errors.New("ntp max offset can't be negative")
+	}
+	if c.MinStratum > c.MaxStratum {
+		return errors.New("ntp min stratum can't be larger than max stratum")
+	}
+	return nil
+}
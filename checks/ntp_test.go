@@ -0,0 +1,101 @@
+package checks
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNtpServer answers one query with the given stratum and a
+// clock that's off by offset.
+func fakeNtpServer(t *testing.T, stratum uint8, offset time.Duration, kiss string) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no udp on loopback:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		req := make([]byte, 48)
+		n, addr, err := conn.ReadFrom(req)
+		if err != nil || n < 48 {
+			return
+		}
+		ntpTime := func(t time.Time) uint64 {
+			d := t.Sub(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC))
+			sec := uint64(d / time.Second)
+			frac := uint64(d%time.Second) << 32 / uint64(time.Second)
+			return sec<<32 | frac
+		}
+		resp := make([]byte, 48)
+		resp[0] = 4<<3 | 4 // version 4, server mode
+		resp[1] = stratum
+		copy(resp[12:16], kiss)
+		copy(resp[24:32], req[40:48])
+		now := time.Now().Add(offset)
+		binary.BigEndian.PutUint64(resp[32:40], ntpTime(now))
+		binary.BigEndian.PutUint64(resp[40:48], ntpTime(now))
+		conn.WriteTo(resp, addr)
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestNtpRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   Ntp
+		stratum uint8
+		offset  time.Duration
+		kiss    string
+		err     string
+	}{
+		{"in sync", Ntp{}, 2, 0, "", ""},
+		{"small offset", Ntp{MaxOffset: 500}, 3, -200 * time.Millisecond, "", ""},
+		{"offset too large", Ntp{MaxOffset: 500}, 3, 2 * time.Second, "", "time offset too large"},
+		{"negative offset too large", Ntp{}, 3, -5 * time.Second, "", "time offset too large"},
+		{"unsynchronized", Ntp{}, 16, 0, "", "stratum 16 out of range"},
+		{"kiss of death", Ntp{}, 0, 0, "RATE", "stratum 0 out of range"},
+		{"stratum below min", Ntp{MinStratum: 3}, 2, 0, "", "stratum 2 out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check.Port = fakeNtpServer(t, tt.stratum, tt.offset, tt.kiss)
+			tt.check.Timeout = 2
+			if err := tt.check.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			res := make(chan Result, 1)
+			tt.check.Run(0, "127.0.0.1", res)
+			result := <-res
+			if tt.err == "" && !result.Status {
+				t.Fatalf("got %s: %s", result.Error, result.Debug)
+			}
+			if tt.err != "" && (result.Status || result.Error != tt.err) {
+				t.Fatalf("got %v %q (%s), want %q", result.Status, result.Error, result.Debug, tt.err)
+			}
+			if tt.kiss != "" && !strings.Contains(result.Debug, "kiss code "+tt.kiss) {
+				t.Errorf("debug %q doesn't have the kiss code", result.Debug)
+			}
+		})
+	}
+}
+
+func TestNtpValidate(t *testing.T) {
+	c := Ntp{}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxOffset != 1000 || c.MinStratum != 1 || c.MaxStratum != 15 {
+		t.Errorf("defaults %d, %d, %d", c.MaxOffset, c.MinStratum, c.MaxStratum)
+	}
+	if err := (&Ntp{MaxOffset: -1}).Validate(); err == nil {
+		t.Error("negative offset allowed")
+	}
+	if err := (&Ntp{MinStratum: 5, MaxStratum: 3}).Validate(); err == nil {
+		t.Error("min stratum over max allowed")
+	}
+}
@@ -8,12 +8,16 @@ import (
 )
 
 type Ping struct {
-	checkBase
+	CheckBase
 	Count           int
 	AllowPacketLoss bool
 	Percent         int
 }
 
+func init() {
+	Register("ping", Definition{Check: Ping{}, Anonymous: true})
+}
+
 func (c Ping) Run(teamID uint, boxIp string, res chan Result) {
 	// Create pinger
 	pinger, err := ping.NewPinger(boxIp)
@@ -28,6 +32,9 @@ func (c Ping) Run(teamID uint, boxIp string, res chan Result) {
 	// Send ping
 	pinger.Count = 1
 	pinger.Timeout = 5 * time.Second
+	if c.Timeout > 0 {
+		pinger.Timeout = c.FetchTimeout()
+	}
 	pinger.SetPrivileged(true)
 	err = pinger.Run()
 	if err != nil {
@@ -61,3 +68,10 @@ func (c Ping) Run(teamID uint, boxIp string, res chan Result) {
 		Status: true,
 	}
 }
+
+func (c *Ping) Validate() error {
+	if c.Count == 0 {
+		c.Count = 1
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package checks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Plugin runs an external program for the check. The program gets a
+// JSON pluginRequest on stdin, and writes a JSON Result to stdout.
+type Plugin struct {
+	CheckBase
+	Command []string               // program and arguments, run without a shell
+	Options map[string]interface{} // passed to the plugin as is
+}
+
+func init() {
+	Register("plugin", Definition{Check: Plugin{}})
+}
+
+type pluginRequest struct {
+	TeamID   uint                   `json:"team_id"`
+	Box      string                 `json:"box"`
+	Check    string                 `json:"check"`
+	IP       string                 `json:"ip"`
+	Port     int                    `json:"port,omitempty"`
+	Username string                 `json:"username,omitempty"`
+	Password string                 `json:"password,omitempty"`
+	Timeout  int                    `json:"timeout"` // seconds
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+func (c Plugin) Run(teamID uint, boxIp string, res chan Result) {
+	req := pluginRequest{
+		TeamID:  teamID,
+		Box:     c.Box,
+		Check:   c.Name,
+		IP:      boxIp,
+		Port:    c.Port,
+		Timeout: int(c.FetchTimeout().Seconds()),
+		Options: c.Options,
+	}
+	if !c.Anonymous {
+		req.Username, req.Password = GetCreds(teamID, c.CredLists, c.Name)
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		res <- Result{
+			Error: "error encoding plugin request",
+			Debug: err.Error(),
+		}
+		return
+	}
+
+	// Kill the plugin if it runs over
+	ctx, cancel := context.WithDeadline(context.Background(), c.deadline())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	// Children of the plugin can hold its output open after it's killed
+	cmd.WaitDelay = c.FetchTimeout() / 20
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	// Plugins can exit non-zero and still report a result
+	var result Result
+	err = json.Unmarshal(stdout.Bytes(), &result)
+	if err != nil {
+		debug := "output was " + strconv.Quote(strings.TrimSpace(stdout.String())) + ", error: " + err.Error()
+		if runErr != nil {
+			debug += ", plugin error: " + runErr.Error()
+		}
+		if stderr.Len() > 0 {
+			debug += ", stderr: " + strings.TrimSpace(stderr.String())
+		}
+		errMsg := "plugin did not return a result"
+		if ctx.Err() != nil {
+			errMsg = "plugin timed out"
+		}
+		res <- Result{
+			Error: errMsg,
+			Debug: debug,
+		}
+		return
+	}
+
+	if result.Partial < 0 || result.Partial > 1 {
+		res <- Result{
+			Error: "plugin returned invalid partial credit",
+			Debug: "partial must be between 0 and 1, got " + strconv.FormatFloat(result.Partial, 'f', -1, 64),
+		}
+		return
+	}
+	if !result.Status && result.Error == "" {
+		result.Error = "plugin reported failure"
+	}
+
+	res <- Result{
+		Status:  result.Status,
+		Error:   result.Error,
+		Debug:   result.Debug,
+		Partial: result.Partial,
+	}
+}
+
+func (c *Plugin) Validate() error {
+	if len(c.Command) == 0 {
+		return errors.New("no command specified for plugin check " + c.Name)
+	}
+	if len(c.CredLists) < 1 {
+		c.Anonymous = true
+	}
+	return nil
+}
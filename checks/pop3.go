@@ -0,0 +1,221 @@
+package checks
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Pop3 struct {
+	CheckBase
+	Encrypted bool
+	StartTls  bool
+	Contains  string
+}
+
+func init() {
+	Register("pop3", Definition{Check: Pop3{}})
+}
+
+// pop3SearchLimit is how many of the newest messages are
+// searched when looking for a string in the mailbox.
+const pop3SearchLimit = 50
+
+type pop3Client struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+func (c Pop3) Run(teamID uint, boxIp string, res chan Result) {
+	cl, err := dialPop3(net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), c.Encrypted, c.StartTls, c.FetchTimeout())
+	if err != nil {
+		res <- Result{
+			Error: "connection to server failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+	defer cl.quit()
+
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
+	err = cl.login(username, password)
+	if err != nil {
+		res <- Result{
+			Error: "login failed",
+			Debug: "creds " + username + ":" + password + ", error: " + err.Error(),
+		}
+		return
+	}
+
+	count, err := cl.stat()
+	if err != nil {
+		res <- Result{
+			Error: "getting mailbox status failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+
+	if c.Contains != "" {
+		found, err := cl.search(c.Contains, count)
+		if err != nil {
+			res <- Result{
+				Error: "retrieving messages failed",
+				Debug: err.Error(),
+			}
+			return
+		}
+		if found == 0 {
+			res <- Result{
+				Error: "no message contained string",
+				Debug: "searched " + strconv.Itoa(count) + " messages for '" + c.Contains + "' with creds " + username + ":" + password,
+			}
+			return
+		}
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  "mailbox has " + strconv.Itoa(count) + " messages with creds " + username + ":" + password,
+	}
+}
+
+// dialPop3 connects to a POP3 server and reads its greeting,
+// optionally wrapping the connection in TLS first (implicit TLS)
+// or upgrading it with STLS (STARTTLS).
+func dialPop3(addr string, encrypted, startTls bool, timeout time.Duration) (*pop3Client, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+	}
+	tlsConfig := tls.Config{
+		InsecureSkipVerify: true,
+	}
+
+	var conn net.Conn
+	var err error
+	if encrypted {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", addr, &tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cl := &pop3Client{conn: conn, text: textproto.NewConn(conn)}
+	if _, err := cl.response(); err != nil {
+		conn.Close()
+		return nil, errors.New("bad greeting: " + err.Error())
+	}
+
+	if startTls {
+		if _, err := cl.cmd("STLS"); err != nil {
+			conn.Close()
+			return nil, errors.New("STLS failed: " + err.Error())
+		}
+		tlsConn := tls.Client(conn, &tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.New("TLS handshake failed: " + err.Error())
+		}
+		cl.conn = tlsConn
+		cl.text = textproto.NewConn(tlsConn)
+	}
+	return cl, nil
+}
+
+// response reads a single status line, returning the text after
+// +OK, or an error containing the text after -ERR.
+func (p *pop3Client) response() (string, error) {
+	line, err := p.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "+OK") {
+		return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+	}
+	return "", errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
+
+func (p *pop3Client) cmd(format string, args ...interface{}) (string, error) {
+	if err := p.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return p.response()
+}
+
+func (p *pop3Client) login(username, password string) error {
+	if _, err := p.cmd("USER %s", username); err != nil {
+		return err
+	}
+	_, err := p.cmd("PASS %s", password)
+	return err
+}
+
+// stat returns the number of messages in the mailbox.
+func (p *pop3Client) stat() (int, error) {
+	line, err := p.cmd("STAT")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 1 {
+		return 0, errors.New("malformed STAT response: " + line)
+	}
+	return strconv.Atoi(fields[0])
+}
+
+// retr returns the full content of message number msg.
+func (p *pop3Client) retr(msg int) (string, error) {
+	if _, err := p.cmd("RETR %d", msg); err != nil {
+		return "", err
+	}
+	lines, err := p.text.ReadDotLines()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// search looks through the newest messages for one containing
+// the given string, returning its message number, or 0 if none
+// matched.
+func (p *pop3Client) search(contains string, count int) (int, error) {
+	for msg := count; msg > 0 && msg > count-pop3SearchLimit; msg-- {
+		content, err := p.retr(msg)
+		if err != nil {
+			return 0, err
+		}
+		if strings.Contains(content, contains) {
+			return msg, nil
+		}
+	}
+	return 0, nil
+}
+
+func (p *pop3Client) quit() {
+	p.cmd("QUIT")
+	p.conn.Close()
+}
+
+func (c *Pop3) Validate() error {
+	if c.Encrypted && c.StartTls {
+		return errors.New("cannot use both encrypted and starttls for pop3")
+	}
+	if c.Port == 0 {
+		if c.Encrypted {
+			c.Port = 995
+		} else {
+			c.Port = 110
+		}
+	}
+	if c.Anonymous {
+		return errors.New("anonymous pop3 not supported")
+	}
+	return nil
+}
@@ -1,16 +1,57 @@
 package checks
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"strconv"
-	// why are there no good rdp libraries?
+	"time"
 )
 
 type Rdp struct {
-	checkBase
+	CheckBase
+	Nla    bool // authenticate with CredSSP using the cred list
+	Domain string
+}
+
+func init() {
+	Register("rdp", Definition{Check: Rdp{}, Port: 3389})
+}
+
+// Security protocols from RDP_NEG_REQ and RDP_NEG_RSP
+const (
+	rdpProtocolRdp      = 0x0
+	rdpProtocolSsl      = 0x1
+	rdpProtocolHybrid   = 0x2
+	rdpProtocolHybridEx = 0x8
+)
+
+var rdpProtocolNames = map[uint32]string{
+	rdpProtocolRdp:      "RDP",
+	rdpProtocolSsl:      "TLS",
+	rdpProtocolHybrid:   "CredSSP (NLA)",
+	rdpProtocolHybridEx: "CredSSP with early user authorization (NLA)",
+}
+
+var rdpFailureCodes = map[uint32]string{
+	1: "server requires TLS",
+	2: "TLS not allowed by server",
+	3: "server has no certificate",
+	4: "inconsistent flags",
+	5: "server requires CredSSP (NLA)",
+	6: "server requires TLS with user authentication",
 }
 
 func (c Rdp) Run(teamID uint, boxIp string, res chan Result) {
-	err := tcpCheck(boxIp + ":" + strconv.Itoa(c.Port))
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), c.FetchTimeout())
 	if err != nil {
 		res <- Result{
 			Error: "connection error",
@@ -18,8 +59,308 @@ func (c Rdp) Run(teamID uint, boxIp string, res chan Result) {
 		}
 		return
 	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.FetchTimeout()))
+
+	protocol, err := rdpNegotiate(conn, rdpProtocolSsl|rdpProtocolHybrid)
+	if err != nil {
+		res <- Result{
+			Error: "rdp negotiation failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+	debug := "negotiated " + rdpProtocolNames[protocol]
+
+	if protocol == rdpProtocolRdp {
+		if c.Nla {
+			res <- Result{
+				Error: "server did not negotiate NLA",
+				Debug: debug,
+			}
+			return
+		}
+		res <- Result{
+			Status: true,
+			Debug:  debug,
+		}
+		return
+	}
+
+	// Everything other than standard RDP security starts with TLS
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	})
+	err = tlsConn.Handshake()
+	if err != nil {
+		res <- Result{
+			Error: "tls handshake failed",
+			Debug: debug + ", error: " + err.Error(),
+		}
+		return
+	}
+
+	if !c.Nla {
+		res <- Result{
+			Status: true,
+			Debug:  debug,
+		}
+		return
+	}
+	if protocol != rdpProtocolHybrid && protocol != rdpProtocolHybridEx {
+		res <- Result{
+			Error: "server did not negotiate NLA",
+			Debug: debug,
+		}
+		return
+	}
+
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
+	err = credsspAuthenticate(tlsConn, username, password, c.Domain)
+	if err != nil {
+		res <- Result{
+			Error: "nla login failed for " + username,
+			Debug: debug + ", creds " + username + ":" + password + ", error: " + err.Error(),
+		}
+		return
+	}
+
 	res <- Result{
 		Status: true,
-		Debug:  "responded",
+		Debug:  debug + ", nla login successful with creds " + username + ":" + password,
+	}
+}
+
+// rdpNegotiate sends an X.224 Connection Request with the requested
+// security protocols, and returns the protocol the server selected.
+func rdpNegotiate(conn net.Conn, requested uint32) (uint32, error) {
+	// TPKT header, X.224 CR TPDU, then RDP_NEG_REQ
+	req := []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.LittleEndian.PutUint32(req[15:], requested)
+	_, err := conn.Write(req)
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return 0, errors.New("error reading connection confirm: " + err.Error())
+	}
+	if header[0] != 0x03 {
+		return 0, errors.New("response is not a TPKT packet")
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < 11 {
+		return 0, errors.New("TPKT packet too short")
+	}
+	body := make([]byte, length-4)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return 0, errors.New("error reading connection confirm: " + err.Error())
+	}
+	if body[1]&0xf0 != 0xd0 {
+		return 0, fmt.Errorf("expected X.224 connection confirm, got TPDU code %#x", body[1])
+	}
+
+	// Servers that only know standard RDP security don't
+	// send any negotiation data
+	if len(body) < 15 {
+		return rdpProtocolRdp, nil
+	}
+	neg := body[7:]
+	value := binary.LittleEndian.Uint32(neg[4:])
+	switch neg[0] {
+	case 0x02: // RDP_NEG_RSP
+		if _, ok := rdpProtocolNames[value]; !ok {
+			return 0, fmt.Errorf("server selected unknown protocol %#x", value)
+		}
+		return value, nil
+	case 0x03: // RDP_NEG_FAILURE
+		if value == 2 {
+			return rdpProtocolRdp, nil
+		}
+		if reason, ok := rdpFailureCodes[value]; ok {
+			return 0, errors.New(reason)
+		}
+		return 0, fmt.Errorf("negotiation failure code %d", value)
+	}
+	return 0, fmt.Errorf("unknown negotiation response type %#x", neg[0])
+}
+
+// tsRequest is the CredSSP message (MS-CSSP 2.2.1).
+type tsRequest struct {
+	Version     int            `asn1:"explicit,tag:0"`
+	NegoTokens  []credsspToken `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte         `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte         `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int            `asn1:"explicit,optional,default:0,tag:4"`
+	ClientNonce []byte         `asn1:"explicit,optional,tag:5"`
+}
+
+type credsspToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+const credsspVersion = 6
+
+// credsspAuthenticate runs NTLM over CredSSP, and proves the login
+// worked by checking the server's answer to our public key binding.
+// Credentials are never delegated to the server.
+func credsspAuthenticate(conn *tls.Conn, username, password, domain string) error {
+	// The server's public key, which both sides bind the login to
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("server sent no certificate")
+	}
+	_, err := asn1.Unmarshal(certs[0].RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return errors.New("error parsing server public key: " + err.Error())
+	}
+	pubKey := spki.PublicKey.Bytes
+
+	ntlm := newNtlmClient(username, password, domain)
+	err = writeTsRequest(conn, tsRequest{
+		Version:    credsspVersion,
+		NegoTokens: []credsspToken{{ntlm.negotiate()}},
+	})
+	if err != nil {
+		return err
+	}
+
+	challenge, err := readTsRequest(conn)
+	if err != nil {
+		return err
+	}
+	if len(challenge.NegoTokens) == 0 {
+		return errors.New("no ntlm challenge from server")
+	}
+	authenticate, err := ntlm.authenticate(challenge.NegoTokens[0].Token)
+	if err != nil {
+		return err
+	}
+
+	// Versions 5 and up hash the public key with a nonce, older
+	// versions encrypt the key itself
+	version := credsspVersion
+	if challenge.Version < version {
+		version = challenge.Version
+	}
+	var clientBinding, serverBinding, nonce []byte
+	if version >= 5 {
+		nonce = make([]byte, 32)
+		rand.Read(nonce)
+		clientHash := sha256.Sum256(bytes.Join([][]byte{[]byte("CredSSP Client-To-Server Binding Hash\x00"), nonce, pubKey}, nil))
+		serverHash := sha256.Sum256(bytes.Join([][]byte{[]byte("CredSSP Server-To-Client Binding Hash\x00"), nonce, pubKey}, nil))
+		clientBinding = clientHash[:]
+		serverBinding = serverHash[:]
+	} else {
+		clientBinding = pubKey
+		serverBinding = append([]byte{}, pubKey...)
+		serverBinding[0]++
+	}
+
+	err = writeTsRequest(conn, tsRequest{
+		Version:     credsspVersion,
+		NegoTokens:  []credsspToken{{authenticate}},
+		PubKeyAuth:  ntlm.seal(clientBinding),
+		ClientNonce: nonce,
+	})
+	if err != nil {
+		return err
+	}
+
+	// A bad login gets an error code (or the connection closed),
+	// a good one gets the server's public key binding back
+	reply, err := readTsRequest(conn)
+	if err != nil {
+		return err
+	}
+	if len(reply.PubKeyAuth) == 0 {
+		return errors.New("server did not accept login")
+	}
+	binding, err := ntlm.unseal(reply.PubKeyAuth)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(binding, serverBinding) {
+		return errors.New("server public key binding did not match")
+	}
+	return nil
+}
+
+func writeTsRequest(conn io.Writer, req tsRequest) error {
+	data, err := asn1.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// maxTsRequest is the largest credssp message read. Real ones
+// are a few kilobytes at most.
+const maxTsRequest = 64 * 1024
+
+// readTsRequest reads one DER encoded TSRequest from the connection.
+func readTsRequest(conn io.Reader) (tsRequest, error) {
+	var req tsRequest
+	header := make([]byte, 2)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return req, errors.New("error reading credssp message: " + err.Error())
+	}
+	data := header
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if len(lengthBytes) > 4 {
+			return req, errors.New("credssp message too long")
+		}
+		_, err = io.ReadFull(conn, lengthBytes)
+		if err != nil {
+			return req, errors.New("error reading credssp message: " + err.Error())
+		}
+		data = append(data, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	// The length comes from the team's server, so don't trust it
+	if length > maxTsRequest {
+		return req, errors.New("credssp message too long (" + strconv.Itoa(length) + " bytes)")
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return req, errors.New("error reading credssp message: " + err.Error())
+	}
+	_, err = asn1.Unmarshal(append(data, body...), &req)
+	if err != nil {
+		return req, errors.New("error parsing credssp message: " + err.Error())
+	}
+	if req.ErrorCode != 0 {
+		return req, fmt.Errorf("server returned error %#x", uint32(req.ErrorCode))
+	}
+	return req, nil
+}
+
+func (c *Rdp) Validate() error {
+	if c.Nla && c.Anonymous {
+		return errors.New("can't use anonymous with nla for rdp check " + c.Name)
+	}
+	if !c.Nla {
+		c.Anonymous = true
 	}
+	return nil
 }
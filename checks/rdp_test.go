@@ -0,0 +1,109 @@
+package checks
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// rdpConfirm builds an X.224 Connection Confirm, with negotiation
+// data if neg isn't empty.
+func rdpConfirm(neg []byte) []byte {
+	body := append([]byte{0x00, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00}, neg...)
+	body[0] = byte(len(body) - 1)
+	length := 4 + len(body)
+	return append([]byte{0x03, 0x00, byte(length >> 8), byte(length)}, body...)
+}
+
+func TestRdpNegotiate(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []byte
+		want     uint32
+		err      string
+	}{
+		{"tls", rdpConfirm([]byte{0x02, 0x00, 0x08, 0x00, 0x01, 0x00, 0x00, 0x00}), rdpProtocolSsl, ""},
+		{"nla", rdpConfirm([]byte{0x02, 0x00, 0x08, 0x00, 0x02, 0x00, 0x00, 0x00}), rdpProtocolHybrid, ""},
+		{"no negotiation data", rdpConfirm(nil), rdpProtocolRdp, ""},
+		{"tls not allowed", rdpConfirm([]byte{0x03, 0x00, 0x08, 0x00, 0x02, 0x00, 0x00, 0x00}), rdpProtocolRdp, ""},
+		{"nla required", rdpConfirm([]byte{0x03, 0x00, 0x08, 0x00, 0x05, 0x00, 0x00, 0x00}), 0, "server requires CredSSP (NLA)"},
+		{"unknown protocol", rdpConfirm([]byte{0x02, 0x00, 0x08, 0x00, 0x40, 0x00, 0x00, 0x00}), 0, "unknown protocol"},
+		{"not tpkt", []byte("SSH-2.0-OpenSSH_9.0\r\n"), 0, "not a TPKT packet"},
+		{"not a confirm", []byte{0x03, 0x00, 0x00, 0x0b, 0x06, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00}, 0, "expected X.224 connection confirm"},
+		{"too short", []byte{0x03, 0x00, 0x00, 0x05, 0x00}, 0, "too short"},
+	}
+
+	for _, tt := range tests {
+		tt := tt // used by the server goroutine
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				request := make([]byte, 19)
+				if _, err := io.ReadFull(server, request); err != nil {
+					return
+				}
+				// Requested protocols are in the RDP_NEG_REQ
+				if !bytes.Equal(request[15:], []byte{0x03, 0x00, 0x00, 0x00}) {
+					return
+				}
+				server.Write(tt.response)
+			}()
+
+			got, err := rdpNegotiate(client, rdpProtocolSsl|rdpProtocolHybrid)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got protocol %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTsRequest(t *testing.T) {
+	valid, err := asn1.Marshal(tsRequest{Version: credsspVersion, NegoTokens: []credsspToken{{Token: []byte("token")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{"valid", valid, ""},
+		{"huge length", []byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff}, "credssp message too long"},
+		{"just over the limit", []byte{0x30, 0x83, 0x01, 0x00, 0x01}, "credssp message too long"},
+		{"length too wide", []byte{0x30, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, "credssp message too long"},
+		{"truncated", valid[:len(valid)-2], "error reading credssp message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readTsRequest(bytes.NewReader(tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Version != credsspVersion || len(req.NegoTokens) != 1 || string(req.NegoTokens[0].Token) != "token" {
+				t.Errorf("decoded %+v", req)
+			}
+		})
+	}
+}
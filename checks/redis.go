@@ -0,0 +1,131 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Redis struct {
+	CheckBase
+	Acl bool // AUTH with username and password, instead of just the password
+	Db  int
+	Key []redisKey
+}
+
+func init() {
+	Register("redis", Definition{Check: Redis{}, Port: 6379})
+}
+
+type redisKey struct {
+	Key      string
+	UseRegex bool
+	Output   string
+}
+
+func (c Redis) Run(teamID uint, boxIp string, res chan Result) {
+	options := &redis.Options{
+		Addr:         net.JoinHostPort(boxIp, strconv.Itoa(c.Port)),
+		DB:           c.Db,
+		DialTimeout:  c.FetchTimeout(),
+		ReadTimeout:  c.FetchTimeout(),
+		WriteTimeout: c.FetchTimeout(),
+		MaxRetries:   -1,
+		Protocol:     2,
+	}
+
+	credDebug := "anonymous"
+	if !c.Anonymous {
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
+		options.Password = password
+		if c.Acl {
+			options.Username = username
+			credDebug = "creds " + username + ":" + password
+		} else {
+			credDebug = "password " + password
+		}
+	}
+
+	client := redis.NewClient(options)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.FetchTimeout())
+	defer cancel()
+
+	// Connecting runs AUTH, so this is the login check too
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		res <- Result{
+			Error: "connection or login failed",
+			Debug: err.Error() + " with " + credDebug,
+		}
+		return
+	}
+
+	if len(c.Key) == 0 {
+		res <- Result{
+			Status: true,
+			Debug:  "ping successful with " + credDebug,
+		}
+		return
+	}
+
+	k := c.Key[rand.Intn(len(c.Key))]
+	value, err := client.Get(ctx, k.Key).Result()
+	if err == redis.Nil {
+		res <- Result{
+			Error: "key " + k.Key + " does not exist",
+			Debug: "with " + credDebug,
+		}
+		return
+	} else if err != nil {
+		res <- Result{
+			Error: "could not get key " + k.Key,
+			Debug: err.Error() + " with " + credDebug,
+		}
+		return
+	}
+
+	if k.Output != "" {
+		var found bool
+		if k.UseRegex {
+			re := regexp.MustCompile(k.Output)
+			found = re.MatchString(value)
+		} else {
+			found = strings.TrimSpace(value) == k.Output
+		}
+		if !found {
+			res <- Result{
+				Error: "key " + k.Key + " had incorrect value",
+				Debug: "got '" + value + "', wanted '" + k.Output + "' with " + credDebug,
+			}
+			return
+		}
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  "key " + k.Key + " was '" + value + "' with " + credDebug,
+	}
+}
+
+func (c *Redis) Validate() error {
+	if c.Acl && c.Anonymous {
+		return errors.New("can't use anonymous with acl for redis check " + c.Name)
+	}
+	for _, k := range c.Key {
+		if k.Key == "" {
+			return errors.New("redis key missing name for " + c.Name)
+		}
+		if k.UseRegex {
+			regexp.MustCompile(k.Output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package checks
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Definition describes a check type for the config file. Check
+// types register themselves (usually in init) so boxes can use them.
+type Definition struct {
+	Check     Check  // zero value of the check type (ex. Ssh{})
+	Display   string // default display name, defaults to the config key
+	Port      int    // default port, if it doesn't depend on other options
+	Anonymous bool   // check never logs in
+}
+
+// Validator is implemented by checks that have options to validate,
+// or defaults that depend on other options. Validate is called after
+// the definition's defaults are filled in.
+type Validator interface {
+	Validate() error
+}
+
+// configurable lets the registry set the embedded CheckBase.
+type configurable interface {
+	base() *CheckBase
+}
+
+var (
+	// Registered check types by config key (ex. "ssh" for [[box.ssh]])
+	registry = make(map[string]Definition)
+	keys     = make(map[reflect.Type]string)
+)
+
+// Register adds a check type under its config key. Check types
+// must embed CheckBase.
+func Register(key string, def Definition) {
+	key = strings.ToLower(key)
+	if _, ok := registry[key]; ok {
+		panic("check type " + key + " registered twice")
+	}
+	checkType := reflect.TypeOf(def.Check)
+	if _, ok := reflect.New(checkType).Interface().(configurable); !ok {
+		panic("check type " + key + " does not embed CheckBase")
+	}
+	if def.Display == "" {
+		def.Display = key
+	}
+	registry[key] = def
+	keys[checkType] = key
+}
+
+// Registered returns the config keys of every check type, sorted.
+func Registered() []string {
+	list := []string{}
+	for key := range registry {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Lookup returns the check type registered for a config key.
+func Lookup(key string) (Definition, bool) {
+	def, ok := registry[strings.ToLower(key)]
+	return def, ok
+}
+
+// Key returns the config key a check's type is registered under.
+func Key(check Check) string {
+	return keys[reflect.TypeOf(check)]
+}
+
+// Setup fills in a check's defaults from its definition and the box
+// it's on, then validates it. ip gives the box address to use, based
+// on whether the check wants ipv6.
+func Setup(check Check, box string, ip func(ipv6 bool) string) (Check, error) {
+	key, ok := keys[reflect.TypeOf(check)]
+	if !ok {
+		return nil, errors.New("unregistered check type " + reflect.TypeOf(check).String())
+	}
+	def := registry[key]
+
+	// Work on a pointer so Validate can set defaults
+	ptr := reflect.New(reflect.TypeOf(check))
+	ptr.Elem().Set(reflect.ValueOf(check))
+	c := ptr.Interface().(configurable).base()
+
+	c.Box = box
+	c.IP = ip(c.Ipv6)
+	if c.Display == "" {
+		c.Display = def.Display
+	}
+	if c.Name == "" {
+		c.Name = box + "-" + c.Display
+	}
+	if c.Port == 0 {
+		c.Port = def.Port
+	}
+	if def.Anonymous {
+		c.Anonymous = true
+	}
+
+	if v, ok := ptr.Interface().(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return ptr.Elem().Interface().(Check), nil
+}
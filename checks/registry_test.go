@@ -0,0 +1,90 @@
+package checks
+
+import (
+	"testing"
+)
+
+// unregistered embeds CheckBase but was never registered.
+type unregistered struct {
+	CheckBase
+}
+
+func (c unregistered) Run(teamID uint, boxIp string, res chan Result) {}
+
+func TestSetup(t *testing.T) {
+	ip := func(ipv6 bool) string {
+		if ipv6 {
+			return "fd00::5"
+		}
+		return "10.20.1.5"
+	}
+
+	tests := []struct {
+		name      string
+		check     Check
+		wantName  string
+		display   string
+		ip        string
+		port      int
+		anonymous bool
+		err       string
+	}{
+		{"defaults", Ntp{}, "castle-ntp", "ntp", "10.20.1.5", 123, true, ""},
+		{"display names the check", Ntp{CheckBase: CheckBase{Display: "time"}}, "castle-time", "time", "10.20.1.5", 123, true, ""},
+		{"name kept", Ntp{CheckBase: CheckBase{Name: "clock", Port: 1123}}, "clock", "ntp", "10.20.1.5", 1123, true, ""},
+		{"ipv6", Ntp{CheckBase: CheckBase{Ipv6: true}}, "castle-ntp", "ntp", "fd00::5", 123, true, ""},
+		{"not anonymous", Ldap{CheckBase: CheckBase{Port: 389}}, "castle-ldap", "ldap", "10.20.1.5", 389, false, ""},
+		{"validate sets defaults", Ldap{StartTls: true}, "castle-ldap", "ldap", "10.20.1.5", 389, false, ""},
+		{"validate errors", Tcp{}, "", "", "", 0, false, "tcp port required"},
+		{"unregistered", unregistered{}, "", "", "", 0, false, "unregistered check type checks.unregistered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, err := Setup(tt.check, "castle", ip)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if check.FetchName() != tt.wantName || check.FetchDisplay() != tt.display || check.FetchIP() != tt.ip || check.FetchPort() != tt.port || check.FetchAnonymous() != tt.anonymous {
+				t.Errorf("got %s %s %s:%d anonymous %v", check.FetchName(), check.FetchDisplay(), check.FetchIP(), check.FetchPort(), check.FetchAnonymous())
+			}
+		})
+	}
+
+	// Setup works on a copy, and keeps defaults set by Validate
+	original := Ntp{}
+	check, err := Setup(original, "castle", ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original.Name != "" || check.(Ntp).MaxStratum != 15 {
+		t.Errorf("original %+v, setup %+v", original, check)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if def, ok := Lookup("NTP"); !ok || def.Port != 123 {
+		t.Errorf("lookup is case sensitive: %+v, %v", def, ok)
+	}
+	if Key(Ntp{}) != "ntp" || Key(unregistered{}) != "" {
+		t.Errorf("keys %q, %q", Key(Ntp{}), Key(unregistered{}))
+	}
+
+	panics := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s didn't panic", name)
+			}
+		}()
+		f()
+	}
+	panics("duplicate key", func() { Register("Ntp", Definition{Check: unregistered{}}) })
+	// A pointer doesn't embed CheckBase itself
+	panics("no CheckBase", func() { Register("unregistered", Definition{Check: &Ntp{}}) })
+}
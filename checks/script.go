@@ -0,0 +1,431 @@
+package checks
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Script runs a Starlark script from checkfiles/. The script defines
+// a check() function, and gets helpers to talk to the box with.
+// Scripts can't load modules or touch the filesystem.
+type Script struct {
+	CheckBase
+	File    string
+	Options map[string]interface{} // available to the script as options
+}
+
+func init() {
+	Register("script", Definition{Check: Script{}})
+}
+
+func (c Script) Run(teamID uint, boxIp string, res chan Result) {
+	src, err := GetFile(c.File)
+	if err != nil {
+		res <- Result{
+			Error: "error reading script",
+			Debug: err.Error(),
+		}
+		return
+	}
+
+	deadline := c.deadline()
+
+	// Anything the script prints goes in the debug output
+	printed := []string{}
+	withPrinted := func(debug string) string {
+		if debug != "" {
+			printed = append(printed, debug)
+		}
+		return strings.Join(printed, "\n")
+	}
+	thread := &starlark.Thread{
+		Name: c.Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			printed = append(printed, msg)
+		},
+	}
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		thread.Cancel("script timed out")
+	})
+	defer timer.Stop()
+
+	options, err := toStarlark(c.Options)
+	if err != nil {
+		res <- Result{
+			Error: "invalid script options",
+			Debug: err.Error(),
+		}
+		return
+	}
+	sh := scriptHelpers{
+		check:    c,
+		teamID:   teamID,
+		boxIp:    boxIp,
+		deadline: deadline,
+	}
+	predeclared := starlark.StringDict{
+		"ip":      starlark.String(boxIp),
+		"port":    starlark.MakeInt(c.Port),
+		"team_id": starlark.MakeUint(teamID),
+		"options": options,
+		"addr":    starlark.NewBuiltin("addr", sh.addr),
+		"creds":   starlark.NewBuiltin("creds", sh.creds),
+		"tcp":     starlark.NewBuiltin("tcp", sh.tcp),
+		"udp":     starlark.NewBuiltin("udp", sh.udp),
+		"http":    starlark.NewBuiltin("http", sh.http),
+		"dns":     starlark.NewBuiltin("dns", sh.dns),
+	}
+
+	globals, err := starlark.ExecFile(thread, c.File, src, predeclared)
+	if err != nil {
+		res <- Result{
+			Error: "error loading script",
+			Debug: withPrinted(scriptError(err)),
+		}
+		return
+	}
+	check, ok := globals["check"].(starlark.Callable)
+	if !ok {
+		res <- Result{
+			Error: "script has no check function",
+			Debug: withPrinted(""),
+		}
+		return
+	}
+
+	value, err := starlark.Call(thread, check, nil, nil)
+	if err != nil {
+		res <- Result{
+			Error: "script failed",
+			Debug: withPrinted(scriptError(err)),
+		}
+		return
+	}
+	result, err := scriptResult(value)
+	if err != nil {
+		res <- Result{
+			Error: "script returned an invalid result",
+			Debug: withPrinted(err.Error()),
+		}
+		return
+	}
+	result.Debug = withPrinted(result.Debug)
+	res <- result
+}
+
+func (c *Script) Validate() error {
+	if c.File == "" {
+		return errors.New("no file specified for script check " + c.Name)
+	}
+	if err := c.compile(); err != nil {
+		return errors.New("invalid script for check " + c.Name + ": " + err.Error())
+	}
+	if len(c.CredLists) < 1 {
+		c.Anonymous = true
+	}
+	return nil
+}
+
+// compile makes sure the script exists and compiles.
+func (c Script) compile() error {
+	src, err := GetFile(c.File)
+	if err != nil {
+		return err
+	}
+	_, _, err = starlark.SourceProgram(c.File, src, func(name string) bool {
+		switch name {
+		case "ip", "port", "team_id", "options", "addr", "creds", "tcp", "udp", "http", "dns":
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	_, err = toStarlark(c.Options)
+	return err
+}
+
+// scriptResult maps what check() returned onto a Result. Scripts
+// can return a bool, an error string, or a dict with the Result
+// fields (status, error, debug, partial).
+func scriptResult(value starlark.Value) (Result, error) {
+	switch v := value.(type) {
+	case starlark.Bool:
+		if v {
+			return Result{Status: true}, nil
+		}
+		return Result{Error: "script reported failure"}, nil
+	case starlark.String:
+		return Result{Error: string(v)}, nil
+	case *starlark.Dict:
+		var result Result
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return result, errors.New("result keys must be strings")
+			}
+			switch key {
+			case "status":
+				result.Status = bool(item[1].Truth())
+			case "error", "debug":
+				s, ok := starlark.AsString(item[1])
+				if !ok {
+					return result, errors.New(key + " must be a string")
+				}
+				if key == "error" {
+					result.Error = s
+				} else {
+					result.Debug = s
+				}
+			case "partial":
+				f, ok := starlark.AsFloat(item[1])
+				if !ok || f < 0 || f > 1 {
+					return result, errors.New("partial must be a number between 0 and 1")
+				}
+				result.Partial = f
+			default:
+				return result, errors.New("unknown result key " + key)
+			}
+		}
+		if !result.Status && result.Error == "" {
+			result.Error = "script reported failure"
+		}
+		return result, nil
+	}
+	return Result{}, errors.New("check() returned " + value.Type() + ", wanted bool, string, or dict")
+}
+
+// scriptError includes the script backtrace, if there is one.
+func scriptError(err error) string {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return evalErr.Backtrace()
+	}
+	return err.Error()
+}
+
+// toStarlark converts decoded TOML values into Starlark values.
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case time.Time:
+		return starlark.String(v.String()), nil
+	case []interface{}:
+		list := []starlark.Value{}
+		for _, item := range v {
+			converted, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return starlark.NewList(list), nil
+	case []map[string]interface{}:
+		list := []starlark.Value{}
+		for _, item := range v {
+			converted, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return starlark.NewList(list), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			converted, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(key), converted)
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported option type %T", value)
+}
+
+// scriptHelpers are the builtins scripts use to talk to the box.
+// They all share the script's deadline.
+type scriptHelpers struct {
+	check    Script
+	teamID   uint
+	boxIp    string
+	deadline time.Time
+}
+
+// addr(port) returns the host:port address of the box.
+func (sh scriptHelpers) addr(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	port := sh.check.Port
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "port?", &port); err != nil {
+		return nil, err
+	}
+	return starlark.String(net.JoinHostPort(sh.boxIp, strconv.Itoa(port))), nil
+}
+
+// creds() returns a (username, password) tuple from the check's cred lists.
+func (sh scriptHelpers) creds(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	username, password := GetCreds(sh.teamID, sh.check.CredLists, sh.check.Name)
+	return starlark.Tuple{starlark.String(username), starlark.String(password)}, nil
+}
+
+// tcp(port, send="", read=True) connects to the box, sends the
+// payload, and returns what the box responds with (up to 4096 bytes).
+func (sh scriptHelpers) tcp(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	port := sh.check.Port
+	var send string
+	read := true
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "port?", &port, "send?", &send, "read?", &read); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(sh.boxIp, strconv.Itoa(port)), time.Until(sh.deadline))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return sh.exchange(conn, send, read)
+}
+
+// udp(port, send) sends a datagram to the box and returns the response.
+func (sh scriptHelpers) udp(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var port int
+	var send string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "port", &port, "send", &send); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(sh.boxIp, strconv.Itoa(port)), time.Until(sh.deadline))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return sh.exchange(conn, send, true)
+}
+
+func (sh scriptHelpers) exchange(conn net.Conn, send string, read bool) (starlark.Value, error) {
+	conn.SetDeadline(sh.deadline)
+	if send != "" {
+		if _, err := conn.Write([]byte(send)); err != nil {
+			return nil, err
+		}
+	}
+	if !read {
+		return starlark.String(""), nil
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return starlark.String(buf[:n]), nil
+}
+
+// http(url, method="GET", body="", headers={}) makes a request and
+// returns a struct with status, body, and headers. URLs starting
+// with / go to the box on the check's port.
+func (sh scriptHelpers) http(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url, body string
+	method := "GET"
+	headers := &starlark.Dict{}
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &url, "method?", &method, "body?", &body, "headers?", &headers); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(url, "/") {
+		url = "http://" + net.JoinHostPort(sh.boxIp, strconv.Itoa(sh.check.Port)) + url
+	}
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range headers.Items() {
+		key, ok1 := starlark.AsString(item[0])
+		value, ok2 := starlark.AsString(item[1])
+		if !ok1 || !ok2 {
+			return nil, errors.New("http headers must be strings")
+		}
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{
+		Timeout: time.Until(sh.deadline),
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	respHeaders := starlark.NewDict(len(resp.Header))
+	for key := range resp.Header {
+		respHeaders.SetKey(starlark.String(strings.ToLower(key)), starlark.String(resp.Header.Get(key)))
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"status":  starlark.MakeInt(resp.StatusCode),
+		"body":    starlark.String(respBody),
+		"headers": respHeaders,
+	}), nil
+}
+
+// dns(name, type="A", port=53) asks the box's DNS server about name,
+// and returns the answers as a list of strings (ex. ["10.20.1.2"]).
+func (sh scriptHelpers) dns(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	kind := "A"
+	port := 53
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "type?", &kind, "port?", &port); err != nil {
+		return nil, err
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(kind)]
+	if !ok {
+		return nil, errors.New("unknown record type " + kind)
+	}
+
+	var msg dns.Msg
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	client := dns.Client{Timeout: time.Until(sh.deadline)}
+	in, _, err := client.Exchange(&msg, net.JoinHostPort(sh.boxIp, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return nil, errors.New("query failed with " + dns.RcodeToString[in.Rcode])
+	}
+
+	answers := []starlark.Value{}
+	for _, rr := range in.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		answers = append(answers, starlark.String(strings.TrimPrefix(rr.String(), rr.Header().String())))
+	}
+	return starlark.NewList(answers), nil
+}
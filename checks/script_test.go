@@ -0,0 +1,119 @@
+package checks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.starlark.net/starlark"
+)
+
+// starlarkValue evaluates a Starlark expression.
+func starlarkValue(t *testing.T, expr string) starlark.Value {
+	t.Helper()
+	globals, err := starlark.ExecFile(&starlark.Thread{}, "test.star", "v = "+expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return globals["v"]
+}
+
+func TestScriptResult(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Result
+		err  string
+	}{
+		{"True", Result{Status: true}, ""},
+		{"False", Result{Error: "script reported failure"}, ""},
+		{`"login page missing"`, Result{Error: "login page missing"}, ""},
+		{`{"status": True, "debug": "3 users"}`, Result{Status: true, Debug: "3 users"}, ""},
+		{`{"status": True, "partial": 0.5}`, Result{Status: true, Partial: 0.5}, ""},
+		{`{"status": True, "partial": 1}`, Result{Status: true, Partial: 1}, ""},
+		{`{"status": False, "debug": "x"}`, Result{Error: "script reported failure", Debug: "x"}, ""},
+		{`{"error": "bad banner"}`, Result{Error: "bad banner"}, ""},
+		{`{"status": 1}`, Result{Status: true}, ""},
+		{`{"status": True, "partial": 1.5}`, Result{}, "partial must be a number between 0 and 1"},
+		{`{"status": True, "partial": "half"}`, Result{}, "partial must be a number between 0 and 1"},
+		{`{"debug": 5}`, Result{}, "debug must be a string"},
+		{`{"points": 5}`, Result{}, "unknown result key points"},
+		{`{1: True}`, Result{}, "result keys must be strings"},
+		{"None", Result{}, "check() returned NoneType, wanted bool, string, or dict"},
+		{"[True]", Result{}, "check() returned list, wanted bool, string, or dict"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := scriptResult(starlarkValue(t, tt.expr))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToStarlark(t *testing.T) {
+	// Options come from TOML, so decode them the same way
+	var options map[string]interface{}
+	_, err := toml.Decode(`
+path = "/login"
+port = 8080
+ratio = 0.5
+strict = true
+users = ["robin", "john"]
+when = 2023-11-04T09:00:00Z
+nested = { depth = 2, tags = [1, 2] }
+[[pages]]
+path = "/"
+[[pages]]
+path = "/shop"
+`, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"path", `"/login"`},
+		{"port", "8080"},
+		{"ratio", "0.5"},
+		{"strict", "True"},
+		{"users", `["robin", "john"]`},
+		{"when", `"2023-11-04 09:00:00 +0000 UTC"`},
+		{"nested", `{"tags": [1, 2], "depth": 2}`},
+		{"pages", `[{"path": "/"}, {"path": "/shop"}]`},
+	}
+	for _, tt := range tests {
+		value, err := toStarlark(options[tt.key])
+		if err != nil {
+			t.Errorf("%s: %v", tt.key, err)
+			continue
+		}
+		// Compared as values, since dict order follows the Go map
+		equal, err := starlark.Equal(value, starlarkValue(t, tt.want))
+		if err != nil || !equal {
+			t.Errorf("%s = %s, want %s", tt.key, value, tt.want)
+		}
+	}
+
+	if value, err := toStarlark(nil); err != nil || value != starlark.None {
+		t.Errorf("nil = %v, %v", value, err)
+	}
+	if _, err := toStarlark(time.Second); err == nil {
+		t.Error("no error for unsupported type")
+	}
+	if _, err := toStarlark([]interface{}{"ok", int32(1)}); err == nil {
+		t.Error("no error for unsupported type in list")
+	}
+}
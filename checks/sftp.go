@@ -0,0 +1,180 @@
+package checks
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+type Sftp struct {
+	CheckBase
+	PrivKey string
+	File    []FtpFile
+	Upload  string // directory to write a file to and read it back from
+}
+
+func init() {
+	Register("sftp", Definition{Check: Sftp{}, Port: 22})
+}
+
+func (c Sftp) Run(teamID uint, boxIp string, res chan Result) {
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
+	credDebug := "creds used were " + username + ":" + password
+	if c.PrivKey != "" {
+		credDebug = "private key " + c.PrivKey + " for user " + username
+	}
+
+	config, result := sshClientConfig(username, password, c.PrivKey, c.FetchTimeout())
+	if result.Error != "" {
+		res <- result
+		return
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), config)
+	if err != nil {
+		res <- Result{
+			Error: "error logging in to ssh server",
+			Debug: credDebug + ", error: " + err.Error(),
+		}
+		return
+	}
+	defer conn.Close()
+
+	// Start the sftp subsystem
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		res <- Result{
+			Error: "failed to start sftp",
+			Debug: credDebug + ", error: " + err.Error(),
+		}
+		return
+	}
+	defer client.Close()
+
+	if c.Upload != "" {
+		name, content := uploadFile(c.Upload)
+		f, err := client.Create(name)
+		if err == nil {
+			_, err = f.Write(content)
+			closeErr := f.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			res <- Result{
+				Error: "failed to upload file",
+				Debug: "tried to write " + name + ", " + credDebug + ", error: " + err.Error(),
+			}
+			return
+		}
+
+		// Read it back before cleaning up
+		var buf []byte
+		f, err = client.Open(name)
+		if err == nil {
+			buf, err = ioutil.ReadAll(f)
+			f.Close()
+		}
+		delErr := client.Remove(name)
+		if err != nil {
+			res <- Result{
+				Error: "failed to read back uploaded file",
+				Debug: "tried to read " + name + ", " + credDebug + ", error: " + err.Error(),
+			}
+			return
+		}
+		if !bytes.Equal(buf, content) {
+			res <- Result{
+				Error: "uploaded file content did not match",
+				Debug: "wrote " + strconv.Itoa(len(content)) + " bytes to " + name + ", read back " + strconv.Itoa(len(buf)),
+			}
+			return
+		}
+		if delErr != nil {
+			res <- Result{
+				Error: "failed to delete uploaded file",
+				Debug: "tried to delete " + name + ", " + credDebug + ", error: " + delErr.Error(),
+			}
+			return
+		}
+	}
+
+	if len(c.File) > 0 {
+		file := c.File[rand.Intn(len(c.File))]
+		f, err := client.Open(file.Name)
+		if err != nil {
+			res <- Result{
+				Error: "failed to open file " + file.Name,
+				Debug: credDebug + ", error: " + err.Error(),
+			}
+			return
+		}
+		defer f.Close()
+		buf, err := ioutil.ReadAll(f)
+		if err != nil {
+			res <- Result{
+				Error: "failed to read sftp file",
+				Debug: "tried to read " + file.Name + ", error: " + err.Error(),
+			}
+			return
+		}
+		if file.Regex != "" {
+			re, err := regexp.Compile(file.Regex)
+			if err != nil {
+				res <- Result{
+					Error: "error compiling regex to match for sftp file",
+					Debug: err.Error(),
+				}
+				return
+			}
+			reFind := re.Find(buf)
+			if reFind == nil {
+				res <- Result{
+					Error: "couldn't find regex in file",
+					Debug: "couldn't find regex \"" + file.Regex + "\" for " + file.Name,
+				}
+				return
+			}
+		} else if file.Hash != "" {
+			fileHash, err := StringHash(string(buf))
+			if err != nil {
+				res <- Result{
+					Error: "error calculating file hash",
+					Debug: err.Error(),
+				}
+				return
+			} else if fileHash != file.Hash {
+				res <- Result{
+					Error: "file hash did not match",
+					Debug: "file " + file.Name + " hash " + fileHash + " did not match specified hash " + file.Hash,
+				}
+				return
+			}
+		}
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  credDebug,
+	}
+}
+
+func (c *Sftp) Validate() error {
+	for _, f := range c.File {
+		if f.Regex != "" && f.Hash != "" {
+			return errors.New("can't have both regex and hash for sftp file check")
+		}
+	}
+	if c.Anonymous {
+		return errors.New("anonymous sftp not supported")
+	}
+	return nil
+}
@@ -1,19 +1,27 @@
 package checks
 
 import (
+	"bytes"
+	"errors"
 	"github.com/hirochachacha/go-smb2"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type Smb struct {
-	checkBase
+	CheckBase
 	Domain string
 	Share  string
 	File   []smbFile
+	Upload string // directory in the share to write a file to and read it back from
+}
+
+func init() {
+	Register("smb", Definition{Check: Smb{}, Port: 445})
 }
 
 type smbFile struct {
@@ -26,9 +34,9 @@ func (c Smb) Run(teamID uint, boxIp string, res chan Result) {
 	// create smb object outside of if statement scope
 
 	// Authenticated SMB
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
 
-	conn, err := net.Dial("tcp", boxIp+":"+strconv.Itoa(c.Port))
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), c.FetchTimeout())
 	if err != nil {
 		res <- Result{
 			Error: "connection failed",
@@ -76,99 +84,153 @@ func (c Smb) Run(teamID uint, boxIp string, res chan Result) {
 	}
 	defer s.Logoff()
 
-	if len(c.File) > 0 {
-		fs, err := s.Mount(c.Share)
-		if err != nil {
-			res <- Result{
-				Error: "failed to mount share",
-				Debug: "share " + c.Share + ", creds " + username + ":" + password,
-			}
-			return
+	if len(c.File) == 0 && c.Upload == "" {
+		res <- Result{
+			Status: true,
+			Error:  "smb login succeeded",
+			Debug:  "creds " + username + ":" + password,
 		}
-		defer fs.Umount()
+		return
+	}
 
-		file := c.File[rand.Intn(len(c.File))]
+	fs, err := s.Mount(c.Share)
+	if err != nil {
+		res <- Result{
+			Error: "failed to mount share",
+			Debug: "share " + c.Share + ", creds " + username + ":" + password,
+		}
+		return
+	}
+	defer fs.Umount()
 
-		f, err := fs.Open(file.Name)
+	if c.Upload != "" {
+		name, content := uploadFile(c.Upload)
+		// Share paths are relative and use backslashes
+		name = strings.TrimLeft(strings.ReplaceAll(name, "/", `\`), `\`)
+		err = fs.WriteFile(name, content, 0644)
 		if err != nil {
 			res <- Result{
-				Error: "failed to open file",
-				Debug: "creds " + username + ":" + password + ", file was " + file.Name + " (" + err.Error() + ")",
+				Error: "failed to upload file",
+				Debug: "creds " + username + ":" + password + ", file was " + name + " (" + err.Error() + ")",
 			}
 			return
 		}
-		defer f.Close()
 
-		buf, err := ioutil.ReadAll(f)
+		// Read it back before cleaning up
+		buf, err := fs.ReadFile(name)
+		delErr := fs.Remove(name)
 		if err != nil {
 			res <- Result{
-				Error: "failed to read file",
-				Debug: "creds " + username + ":" + password + ", file was " + file.Name + " (" + err.Error() + ")",
+				Error: "failed to read back uploaded file",
+				Debug: "creds " + username + ":" + password + ", file was " + name + " (" + err.Error() + ")",
 			}
 			return
 		}
-
-		if file.Regex != "" {
-			re, err := regexp.Compile(file.Regex)
-			if err != nil {
-				res <- Result{
-					Error: "error compiling regex to match for smb file",
-					Debug: err.Error(),
-				}
-				return
-			}
-			reFind := re.Find(buf)
-			if reFind == nil {
-				res <- Result{
-					Error: "couldn't find regex in file",
-					Debug: "couldn't find regex \"" + file.Regex + "\" for " + file.Name,
-				}
-				return
-			}
+		if !bytes.Equal(buf, content) {
 			res <- Result{
-				Status: true,
-				Error:  "smb file matched regex",
-				Debug:  "file " + file.Name + ", creds " + username + ":" + password,
+				Error: "uploaded file content did not match",
+				Debug: "wrote " + strconv.Itoa(len(content)) + " bytes to " + name + ", read back " + strconv.Itoa(len(buf)),
 			}
 			return
-		} else if file.Hash != "" {
-			fileHash, err := StringHash(string(buf))
-			if err != nil {
-				res <- Result{
-					Error: "error calculating file hash",
-					Debug: "file " + file.Name + ", " + err.Error(),
-				}
-				return
-			} else if fileHash != file.Hash {
-				res <- Result{
-					Error: "file hash did not match",
-					Debug: "file " + file.Name + " hash " + fileHash + " did not match specified hash " + file.Hash,
-				}
-				return
+		}
+		if delErr != nil {
+			res <- Result{
+				Error: "failed to delete uploaded file",
+				Debug: "creds " + username + ":" + password + ", file was " + name + " (" + delErr.Error() + ")",
 			}
+			return
+		}
 
+		if len(c.File) == 0 {
 			res <- Result{
 				Status: true,
-				Error:  "smb file matched hash",
-				Debug:  "file " + file.Name + ", creds " + username + ":" + password,
+				Error:  "smb upload succeeded",
+				Debug:  "wrote, read, and deleted " + name + ", creds " + username + ":" + password,
 			}
 			return
-		} else {
+		}
+	}
+
+	file := c.File[rand.Intn(len(c.File))]
+
+	f, err := fs.Open(file.Name)
+	if err != nil {
+		res <- Result{
+			Error: "failed to open file",
+			Debug: "creds " + username + ":" + password + ", file was " + file.Name + " (" + err.Error() + ")",
+		}
+		return
+	}
+	defer f.Close()
 
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		res <- Result{
+			Error: "failed to read file",
+			Debug: "creds " + username + ":" + password + ", file was " + file.Name + " (" + err.Error() + ")",
+		}
+		return
+	}
+
+	if file.Regex != "" {
+		re, err := regexp.Compile(file.Regex)
+		if err != nil {
 			res <- Result{
-				Status: true,
-				Error:  "smb file retrieval successful",
-				Debug:  "file " + file.Name + ", creds " + username + ":" + password,
+				Error: "error compiling regex to match for smb file",
+				Debug: err.Error(),
 			}
 			return
 		}
+		reFind := re.Find(buf)
+		if reFind == nil {
+			res <- Result{
+				Error: "couldn't find regex in file",
+				Debug: "couldn't find regex \"" + file.Regex + "\" for " + file.Name,
+			}
+			return
+		}
+		res <- Result{
+			Status: true,
+			Error:  "smb file matched regex",
+			Debug:  "file " + file.Name + ", creds " + username + ":" + password,
+		}
+		return
+	} else if file.Hash != "" {
+		fileHash, err := StringHash(string(buf))
+		if err != nil {
+			res <- Result{
+				Error: "error calculating file hash",
+				Debug: "file " + file.Name + ", " + err.Error(),
+			}
+			return
+		} else if fileHash != file.Hash {
+			res <- Result{
+				Error: "file hash did not match",
+				Debug: "file " + file.Name + " hash " + fileHash + " did not match specified hash " + file.Hash,
+			}
+			return
+		}
+
+		res <- Result{
+			Status: true,
+			Error:  "smb file matched hash",
+			Debug:  "file " + file.Name + ", creds " + username + ":" + password,
+		}
+		return
 	} else {
+
 		res <- Result{
 			Status: true,
-			Error:  "smb login succeeded",
-			Debug:  "creds " + username + ":" + password,
+			Error:  "smb file retrieval successful",
+			Debug:  "file " + file.Name + ", creds " + username + ":" + password,
 		}
 		return
 	}
+}
 
+func (c *Smb) Validate() error {
+	if c.Upload != "" && c.Share == "" {
+		return errors.New("need share for smb upload for " + c.Name)
+	}
+	return nil
 }
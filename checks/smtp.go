@@ -3,17 +3,24 @@ package checks
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/smtp"
+	"strconv"
 )
 
 type Smtp struct {
-	checkBase
+	CheckBase
 	Sender    string
 	Receiver  string
 	Body      string
 	Encrypted bool
+	TlsPolicy string
+}
+
+func init() {
+	Register("smtp", Definition{Check: Smtp{}, Port: 25})
 }
 
 type unencryptedAuth struct {
@@ -29,30 +36,28 @@ func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error)
 func (c Smtp) Run(teamID uint, boxIp string, res chan Result) {
 	// Create a dialer
 	dialer := net.Dialer{
-		Timeout: GlobalTimeout,
+		Timeout: c.FetchTimeout(),
 	}
 
 	// ***********************************************
 	// Set up custom auth for bypassing net/smtp protections
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
 	auth := unencryptedAuth{smtp.PlainAuth("", username, password, boxIp)}
 	// ***********************************************
 
 	// The good way to do auth
 	// auth := smtp.PlainAuth("", d.Username, d.Password, d.Host)
 	// Create TLS config
-	tlsConfig := tls.Config{
-		InsecureSkipVerify: true,
-	}
+	tlsConfig := tlsPolicyConfig(c.TlsPolicy)
 
 	// Declare these for the below if block
 	var conn net.Conn
 	var err error
 
 	if c.Encrypted {
-		conn, err = tls.DialWithDialer(&dialer, "tcp", fmt.Sprintf("%s:%d", boxIp, c.Port), &tlsConfig)
+		conn, err = tls.DialWithDialer(&dialer, "tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), tlsConfig)
 	} else {
-		conn, err = dialer.DialContext(context.TODO(), "tcp", fmt.Sprintf("%s:%d", boxIp, c.Port))
+		conn, err = dialer.DialContext(context.TODO(), "tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)))
 	}
 	if err != nil {
 		res <- Result{
@@ -131,3 +136,15 @@ func (c Smtp) Run(teamID uint, boxIp string, res chan Result) {
 	}
 	return
 }
+
+func (c *Smtp) Validate() error {
+	if c.TlsPolicy != "" {
+		if !c.Encrypted {
+			return errors.New("tls policy needs encrypted smtp for " + c.Name)
+		}
+		if err := checkTlsPolicy(c.TlsPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package checks
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+type Snmp struct {
+	CheckBase
+	Version      string
+	Community    string
+	AuthProtocol string
+	PrivProtocol string
+	Oid          []snmpOid
+}
+
+func init() {
+	Register("snmp", Definition{Check: Snmp{}, Port: 161})
+}
+
+type snmpOid struct {
+	Oid      string
+	Walk     bool
+	UseRegex bool
+	Output   string
+}
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"NONE":   gosnmp.NoPriv,
+	"DES":    gosnmp.DES,
+	"AES":    gosnmp.AES,
+	"AES192": gosnmp.AES192,
+	"AES256": gosnmp.AES256,
+}
+
+// ValidSnmpProtocols reports whether the auth and priv
+// protocol names are ones the snmp check knows about.
+func ValidSnmpProtocols(auth, priv string) bool {
+	_, authOk := snmpAuthProtocols[auth]
+	_, privOk := snmpPrivProtocols[priv]
+	return authOk && privOk
+}
+
+func (c Snmp) Run(teamID uint, boxIp string, res chan Result) {
+	o := c.Oid[rand.Intn(len(c.Oid))]
+
+	client := &gosnmp.GoSNMP{
+		Target:  boxIp,
+		Port:    uint16(c.Port),
+		Timeout: c.FetchTimeout(),
+		Retries: 0,
+		MaxOids: gosnmp.MaxOids,
+	}
+
+	var credDebug string
+	if c.Version == "3" {
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
+		credDebug = "creds " + username + ":" + password
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = gosnmp.AuthPriv
+		if c.PrivProtocol == "NONE" {
+			client.MsgFlags = gosnmp.AuthNoPriv
+		}
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 username,
+			AuthenticationProtocol:   snmpAuthProtocols[c.AuthProtocol],
+			AuthenticationPassphrase: password,
+			PrivacyProtocol:          snmpPrivProtocols[c.PrivProtocol],
+			PrivacyPassphrase:        password,
+		}
+	} else {
+		client.Version = gosnmp.Version2c
+		client.Community = c.Community
+		if client.Community == "" {
+			// Community strings come from the cred list password,
+			// so teams can change them with PCRs
+			_, client.Community = GetCreds(teamID, c.CredLists, c.Name)
+		}
+		credDebug = "community " + client.Community
+	}
+
+	err := client.Connect()
+	if err != nil {
+		res <- Result{
+			Error: "snmp connection failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+	defer client.Conn.Close()
+
+	var pdus []gosnmp.SnmpPDU
+	if o.Walk {
+		pdus, err = client.BulkWalkAll(o.Oid)
+	} else {
+		var packet *gosnmp.SnmpPacket
+		packet, err = client.Get([]string{o.Oid})
+		if err == nil {
+			pdus = packet.Variables
+		}
+	}
+	if err != nil {
+		res <- Result{
+			Error: "snmp request failed for " + o.Oid,
+			Debug: credDebug + ", error: " + err.Error(),
+		}
+		return
+	}
+
+	values := []string{}
+	for _, pdu := range pdus {
+		if pdu.Type == gosnmp.NoSuchObject || pdu.Type == gosnmp.NoSuchInstance || pdu.Type == gosnmp.EndOfMibView {
+			continue
+		}
+		values = append(values, snmpValue(pdu))
+	}
+	if len(values) == 0 {
+		res <- Result{
+			Error: "no values returned for " + o.Oid,
+			Debug: credDebug,
+		}
+		return
+	}
+
+	if o.Output != "" {
+		found := false
+		for _, value := range values {
+			if o.UseRegex {
+				re := regexp.MustCompile(o.Output)
+				found = re.MatchString(value)
+			} else {
+				found = strings.TrimSpace(value) == o.Output
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			res <- Result{
+				Error: "snmp output incorrect for " + o.Oid,
+				Debug: "didn't find '" + o.Output + "' in " + strings.Join(values, ", ") + " with " + credDebug,
+			}
+			return
+		}
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  o.Oid + " returned " + strings.Join(values, ", ") + " with " + credDebug,
+	}
+}
+
+func snmpValue(pdu gosnmp.SnmpPDU) string {
+	switch v := pdu.Value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return gosnmp.ToBigInt(pdu.Value).String()
+}
+
+func (c *Snmp) Validate() error {
+	if c.Version == "" {
+		c.Version = "2c"
+	}
+	if c.Version != "2c" && c.Version != "3" {
+		return errors.New("snmp version must be 2c or 3 for " + c.Name)
+	}
+	if c.Version == "2c" && c.Community != "" {
+		c.Anonymous = true
+	}
+	if c.Version == "3" && c.Community != "" {
+		return errors.New("can't use community with snmp v3")
+	}
+	if c.AuthProtocol == "" {
+		c.AuthProtocol = "SHA"
+	}
+	if c.PrivProtocol == "" {
+		c.PrivProtocol = "AES"
+	}
+	c.AuthProtocol = strings.ToUpper(c.AuthProtocol)
+	c.PrivProtocol = strings.ToUpper(c.PrivProtocol)
+	if !ValidSnmpProtocols(c.AuthProtocol, c.PrivProtocol) {
+		return errors.New("invalid snmp auth or priv protocol for " + c.Name)
+	}
+	if len(c.Oid) < 1 {
+		return errors.New("snmp check " + c.Name + " has no oids")
+	}
+	for _, o := range c.Oid {
+		if o.UseRegex {
+			regexp.MustCompile(o.Output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestSnmpValue(t *testing.T) {
+	tests := []struct {
+		name string
+		pdu  gosnmp.SnmpPDU
+		want string
+	}{
+		{"octet string", gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte("Sherwood router")}, "Sherwood router"},
+		{"object id", gosnmp.SnmpPDU{Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.8072.3.2.10"}, ".1.3.6.1.4.1.8072.3.2.10"},
+		{"integer", gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: -2}, "-2"},
+		{"timeticks", gosnmp.SnmpPDU{Type: gosnmp.TimeTicks, Value: uint32(123456)}, "123456"},
+		{"counter64", gosnmp.SnmpPDU{Type: gosnmp.Counter64, Value: uint64(1) << 40}, "1099511627776"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snmpValue(tt.pdu); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnmpValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		check     Snmp
+		anonymous bool
+		err       bool
+	}{
+		{"community is anonymous", Snmp{Community: "public"}, true, false},
+		{"community from creds", Snmp{}, false, false},
+		{"v3 defaults", Snmp{Version: "3"}, false, false},
+		{"v3 lowercase protocols", Snmp{Version: "3", AuthProtocol: "sha512", PrivProtocol: "aes256"}, false, false},
+		{"v3 no privacy", Snmp{Version: "3", PrivProtocol: "none"}, false, false},
+		{"v3 with community", Snmp{Version: "3", Community: "public"}, false, true},
+		{"v1", Snmp{Version: "1"}, false, true},
+		{"unknown auth", Snmp{Version: "3", AuthProtocol: "SHA1"}, false, true},
+		{"unknown priv", Snmp{Version: "3", PrivProtocol: "3DES"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.check.Oid == nil {
+				tt.check.Oid = []snmpOid{{Oid: ".1.3.6.1.2.1.1.1.0"}}
+			}
+			err := tt.check.Validate()
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.err {
+				return
+			}
+			if tt.check.FetchAnonymous() != tt.anonymous {
+				t.Errorf("anonymous %v, want %v", tt.check.FetchAnonymous(), tt.anonymous)
+			}
+			if !ValidSnmpProtocols(tt.check.AuthProtocol, tt.check.PrivProtocol) {
+				t.Errorf("protocols %s/%s not valid after validate", tt.check.AuthProtocol, tt.check.PrivProtocol)
+			}
+		})
+	}
+
+	if err := (&Snmp{}).Validate(); err == nil {
+		t.Error("no error without oids")
+	}
+}
@@ -3,18 +3,29 @@ package checks
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/microsoft/go-mssqldb"
 )
 
 type Sql struct {
-	checkBase
-	Kind  string
-	Query []queryData
+	CheckBase
+	Kind      string // mysql, postgres, or mssql
+	Encrypted bool
+	Query     []queryData
+}
+
+func init() {
+	Register("sql", Definition{Check: Sql{}})
 }
 
 type queryData struct {
@@ -28,12 +39,13 @@ type queryData struct {
 }
 
 func (c Sql) Run(teamID uint, boxIp string, res chan Result) {
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
 
 	// Run query
 	q := c.Query[rand.Intn(len(c.Query))]
 
-	db, err := sql.Open(c.Kind, fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", username, password, boxIp, c.Port, q.Database))
+	driver, dsn := c.dsn(username, password, boxIp, q.Database)
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		res <- Result{
 			Error: "creating db handle failed",
@@ -57,7 +69,7 @@ func (c Sql) Run(teamID uint, boxIp string, res chan Result) {
 	// TODO: This is SQL injectable. Figure out Paramerterized queries. not that it really matters...
 	var rows *sql.Rows
 	if q.DatabaseExists {
-		rows, err = db.QueryContext(context.TODO(), fmt.Sprint("SHOW DATABASES;"))
+		rows, err = db.QueryContext(context.TODO(), c.listDatabases())
 		if err != nil {
 			res <- Result{
 				Error: "could not query db for database " + q.Database,
@@ -150,3 +162,97 @@ func (c Sql) Run(teamID uint, boxIp string, res chan Result) {
 		Debug:  "creds used were " + username + ":" + password,
 	}
 }
+
+// dsn returns the driver name and data source name for the kind
+// of database server.
+func (c Sql) dsn(username, password, boxIp, database string) (string, string) {
+	addr := net.JoinHostPort(boxIp, strconv.Itoa(c.Port))
+	switch c.Kind {
+	case "postgres":
+		sslmode := "disable"
+		if c.Encrypted {
+			sslmode = "require"
+		}
+		if database == "" {
+			database = "postgres"
+		}
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(username, password),
+			Host:     addr,
+			Path:     "/" + database,
+			RawQuery: "sslmode=" + sslmode + "&connect_timeout=" + strconv.Itoa(int(c.FetchTimeout().Seconds())),
+		}
+		return "postgres", u.String()
+	case "mssql":
+		query := url.Values{}
+		query.Set("dial timeout", strconv.Itoa(int(c.FetchTimeout().Seconds())))
+		query.Set("TrustServerCertificate", "true")
+		if database != "" {
+			query.Set("database", database)
+		}
+		// The driver tries TLS by default, so always say which we want
+		if c.Encrypted {
+			query.Set("encrypt", "true")
+		} else {
+			query.Set("encrypt", "disable")
+		}
+		u := url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(username, password),
+			Host:     addr,
+			RawQuery: query.Encode(),
+		}
+		return "sqlserver", u.String()
+	}
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?timeout=%s", username, password, addr, database, c.FetchTimeout())
+	if c.Encrypted {
+		dsn += "&tls=skip-verify"
+	}
+	return "mysql", dsn
+}
+
+// listDatabases returns the query for database names.
+func (c Sql) listDatabases() string {
+	switch c.Kind {
+	case "postgres":
+		return "SELECT datname FROM pg_database;"
+	case "mssql":
+		return "SELECT name FROM sys.databases;"
+	}
+	return "SHOW DATABASES;"
+}
+
+func (c *Sql) Validate() error {
+	if c.Kind == "" {
+		c.Kind = "mysql"
+	}
+	if c.Port == 0 {
+		switch c.Kind {
+		case "mysql":
+			c.Port = 3306
+		case "postgres":
+			c.Port = 5432
+		case "mssql":
+			c.Port = 1433
+		}
+	}
+	if c.Kind != "mysql" && c.Kind != "postgres" && c.Kind != "mssql" {
+		return errors.New("invalid sql kind for " + c.Name + ": " + c.Kind + " (must be mysql, postgres, or mssql)")
+	}
+	for _, q := range c.Query {
+		if q.DatabaseExists && (q.Column != "" || q.Table != "" || q.Output != "") {
+			return errors.New("cannot use both database exists check and row check")
+		}
+		if q.DatabaseExists && q.Database == "" {
+			return errors.New("must specify database for database exists check")
+		}
+		if q.UseRegex {
+			regexp.MustCompile(q.Output)
+		}
+		if q.UseRegex && q.Contains {
+			return errors.New("cannot use both regex and contains")
+		}
+	}
+	return nil
+}
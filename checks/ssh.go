@@ -2,8 +2,11 @@ package checks
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
@@ -15,10 +18,15 @@ import (
 )
 
 type Ssh struct {
-	checkBase
+	CheckBase
 	PrivKey     string
 	BadAttempts int
 	Command     []commandData
+	Partial     bool // run every command, and give partial credit for the ones that pass
+}
+
+func init() {
+	Register("ssh", Definition{Check: Ssh{}, Port: 22})
 }
 
 type commandData struct {
@@ -30,38 +38,11 @@ type commandData struct {
 
 func (c Ssh) Run(teamID uint, boxIp string, res chan Result) {
 	// Create client config
-	username, password := getCreds(teamID, c.CredLists, c.Name)
-	config := &ssh.ClientConfig{
-		User:            username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         GlobalTimeout,
-	}
-	config.SetDefaults()
-	config.Ciphers = append(config.Ciphers, "3des-cbc")
-	if c.PrivKey != "" {
-		key, err := os.ReadFile("./checkfiles/" + c.PrivKey)
-		if err != nil {
-			res <- Result{
-				Error: "error opening pubkey",
-				Debug: err.Error(),
-			}
-			return
-		}
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			res <- Result{
-				Error: "error parsing private key",
-				Debug: err.Error(),
-			}
-			return
-		}
-		config.Auth = []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		}
-	} else {
-		config.Auth = []ssh.AuthMethod{
-			ssh.Password(password),
-		}
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
+	config, result := sshClientConfig(username, password, c.PrivKey, c.FetchTimeout())
+	if result.Error != "" {
+		res <- result
+		return
 	}
 
 	for i := 0; i < c.BadAttempts; i++ {
@@ -71,17 +52,17 @@ func (c Ssh) Run(teamID uint, boxIp string, res chan Result) {
 				ssh.Password(uuid.New().String()),
 			},
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         GlobalTimeout,
+			Timeout:         c.FetchTimeout(),
 		}
 
-		badConn, err := ssh.Dial("tcp", boxIp+":"+strconv.Itoa(c.Port), badConf)
+		badConn, err := ssh.Dial("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), badConf)
 		if err == nil {
 			badConn.Close()
 		}
 	}
 
 	// Connect to ssh server
-	conn, err := ssh.Dial("tcp", boxIp+":"+strconv.Itoa(c.Port), config)
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), config)
 	if err != nil {
 		if c.PrivKey != "" {
 			res <- Result{
@@ -98,16 +79,78 @@ func (c Ssh) Run(teamID uint, boxIp string, res chan Result) {
 	}
 	defer conn.Close()
 
+	// Run every command for partial credit
+	if c.Partial && len(c.Command) > 0 {
+		// Leave time to connect, the rest is split between commands
+		wait := c.FetchTimeout() * 3 / 4 / time.Duration(len(c.Command))
+		if wait > c.FetchTimeout()/8 {
+			wait = c.FetchTimeout() / 8
+		}
+		passed := 0
+		failed := Result{}
+		for _, r := range c.Command {
+			result := runSshCommand(conn, r, wait)
+			if result.Status {
+				passed++
+			} else if failed.Error == "" {
+				failed = result
+			}
+		}
+		if passed < len(c.Command) {
+			res <- Result{
+				Error:   strconv.Itoa(len(c.Command)-passed) + " of " + strconv.Itoa(len(c.Command)) + " commands failed, first error: " + failed.Error,
+				Debug:   failed.Debug,
+				Partial: float64(passed) / float64(len(c.Command)),
+			}
+			return
+		}
+		res <- Result{
+			Status: true,
+			Debug:  "creds used were " + username + ":" + password + ", all " + strconv.Itoa(passed) + " commands passed",
+		}
+		return
+	}
+
+	// If any commands specified, run a random one
+	if len(c.Command) > 0 {
+		result := runSshCommand(conn, c.Command[rand.Intn(len(c.Command))], time.Duration(int(c.FetchTimeout())/8))
+		if !result.Status {
+			res <- result
+			return
+		}
+	} else {
+		// Make sure a shell starts
+		shell, result := startShell(conn)
+		if result.Error != "" {
+			res <- result
+			return
+		}
+		shell.session.Close()
+	}
+	res <- Result{
+		Status: true,
+		Debug:  "creds used were " + username + ":" + password,
+	}
+}
+
+type sshShell struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  bytes.Buffer
+	stderr  bytes.Buffer
+}
+
+// startShell opens a session with a pty and starts a shell. The
+// result has an error set if it failed.
+func startShell(conn *ssh.Client) (*sshShell, Result) {
 	// Create a session
 	session, err := conn.NewSession()
 	if err != nil {
-		res <- Result{
+		return nil, Result{
 			Error: "unable to create ssh session",
 			Debug: err.Error(),
 		}
-		return
 	}
-	defer session.Close()
 
 	// Set up terminal modes
 	modes := ssh.TerminalModes{
@@ -118,79 +161,137 @@ func (c Ssh) Run(teamID uint, boxIp string, res chan Result) {
 
 	// Request pseudo terminal
 	if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
-		res <- Result{
+		session.Close()
+		return nil, Result{
 			Error: "couldn't allocate pts",
 			Debug: err.Error(),
 		}
-		return
 	}
 
 	// I/O for shell
-	stdin, err := session.StdinPipe()
+	shell := &sshShell{session: session}
+	shell.stdin, err = session.StdinPipe()
 	if err != nil {
-		res <- Result{
+		session.Close()
+		return nil, Result{
 			Error: "couldn't get stdin pipe",
 			Debug: err.Error(),
 		}
-		return
 	}
-
-	var stdoutBytes bytes.Buffer
-	var stderrBytes bytes.Buffer
-	session.Stdout = &stdoutBytes
-	session.Stderr = &stderrBytes
+	session.Stdout = &shell.stdout
+	session.Stderr = &shell.stderr
 
 	// Start remote shell
 	if err := session.Shell(); err != nil {
-		res <- Result{
+		session.Close()
+		return nil, Result{
 			Error: "failed to start shell",
 			Debug: "error: " + err.Error(),
 		}
-		return
 	}
+	return shell, Result{}
+}
 
-	// If any commands specified, run a random one
-	if len(c.Command) > 0 {
-		r := c.Command[rand.Intn(len(c.Command))]
-		fmt.Fprintln(stdin, r.Command)
-		time.Sleep(time.Duration(int(GlobalTimeout) / 8))
-		if r.Contains {
-			if !strings.Contains(stdoutBytes.String(), r.Output) {
-				res <- Result{
-					Error: "command output didn't contain string",
-					Debug: "command output of '" + r.Command + "' didn't contain string '" + r.Output + "': " + stdoutBytes.String() + ",  " + stderrBytes.String(),
-				}
-				return
+// runSshCommand runs the command in a new shell, waits for
+// it to finish, then checks its output.
+func runSshCommand(conn *ssh.Client, r commandData, wait time.Duration) Result {
+	shell, result := startShell(conn)
+	if result.Error != "" {
+		return result
+	}
+	defer shell.session.Close()
+
+	fmt.Fprintln(shell.stdin, r.Command)
+	time.Sleep(wait)
+	if r.Contains {
+		if !strings.Contains(shell.stdout.String(), r.Output) {
+			return Result{
+				Error: "command output didn't contain string",
+				Debug: "command output of '" + r.Command + "' didn't contain string '" + r.Output + "': " + shell.stdout.String() + ",  " + shell.stderr.String(),
 			}
-		} else if r.UseRegex {
-			re := regexp.MustCompile(r.Output)
-			if !re.Match([]byte(stdoutBytes.String())) {
-				res <- Result{
-					Error: "command output didn't match regex",
-					Debug: "command output'" + r.Command + "' didn't match regex '" + r.Output,
-				}
-				return
-			} else {
-				if strings.TrimSpace(stdoutBytes.String()) != r.Output {
-					res <- Result{
-						Error: "command output didn't match string",
-						Debug: "command output of '" + r.Command + "' didn't match string '" + r.Output,
-					}
-					return
-				}
+		}
+	} else if r.UseRegex {
+		re := regexp.MustCompile(r.Output)
+		if !re.Match([]byte(shell.stdout.String())) {
+			return Result{
+				Error: "command output didn't match regex",
+				Debug: "command output'" + r.Command + "' didn't match regex '" + r.Output,
 			}
 		} else {
-			if stderrBytes.Len() != 0 {
-				res <- Result{
-					Error: "command returned an error",
-					Debug: "command stderr was not empty: " + stderrBytes.String(),
+			if strings.TrimSpace(shell.stdout.String()) != r.Output {
+				return Result{
+					Error: "command output didn't match string",
+					Debug: "command output of '" + r.Command + "' didn't match string '" + r.Output,
 				}
-				return
+			}
+		}
+	} else {
+		if shell.stderr.Len() != 0 {
+			return Result{
+				Error: "command returned an error",
+				Debug: "command stderr was not empty: " + shell.stderr.String(),
 			}
 		}
 	}
-	res <- Result{
-		Status: true,
-		Debug:  "creds used were " + username + ":" + password,
+	return Result{Status: true}
+}
+
+// sshClientConfig returns the client config for logging in with
+// the password, or with the private key in checkfiles/ if one is
+// given. The result has an error set if the key couldn't be loaded.
+func sshClientConfig(username, password, privKey string, timeout time.Duration) (*ssh.ClientConfig, Result) {
+	config := &ssh.ClientConfig{
+		User:            username,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+	}
+	config.SetDefaults()
+	config.Ciphers = append(config.Ciphers, "3des-cbc")
+	if privKey != "" {
+		key, err := os.ReadFile("./checkfiles/" + privKey)
+		if err != nil {
+			return nil, Result{
+				Error: "error opening private key " + privKey,
+				Debug: err.Error(),
+			}
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			return nil, Result{
+				Error: "private key " + privKey + " needs a passphrase",
+				Debug: err.Error(),
+			}
+		} else if err != nil {
+			return nil, Result{
+				Error: "error parsing private key " + privKey,
+				Debug: err.Error(),
+			}
+		}
+		config.Auth = []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		}
+	} else {
+		config.Auth = []ssh.AuthMethod{
+			ssh.Password(password),
+		}
+	}
+	return config, Result{}
+}
+
+func (c *Ssh) Validate() error {
+	if c.PrivKey != "" && c.BadAttempts != 0 {
+		return errors.New("can not have bad attempts with pubkey for ssh")
+	}
+	for _, r := range c.Command {
+		if r.UseRegex {
+			regexp.MustCompile(r.Output)
+		}
+		if r.UseRegex && r.Contains {
+			return errors.New("cannot use both regex and contains")
+		}
+	}
+	if c.Anonymous {
+		return errors.New("anonymous ssh not supported")
 	}
+	return nil
 }
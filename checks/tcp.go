@@ -1,15 +1,53 @@
 package checks
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"regexp"
 	"strconv"
+	"time"
 )
 
 type Tcp struct {
-	checkBase
+	CheckBase
+	sendExpect
+}
+
+func init() {
+	Register("tcp", Definition{Check: Tcp{}, Anonymous: true})
+}
+
+// sendExpect is an optional payload to send, and what the
+// response has to look like. Used by tcp and udp checks.
+type sendExpect struct {
+	Send      string
+	SendHex   bool   // Send is hex encoded
+	ReadBytes int    // max bytes to read
+	Regex     string // regex the response must match
+	ExpectHex string // hex encoded bytes the response must contain
 }
 
 func (c Tcp) Run(teamID uint, boxIp string, res chan Result) {
-	err := tcpCheck(boxIp + ":" + strconv.Itoa(c.Port))
+	if !c.expectsResponse() && c.Send == "" {
+		err := tcpCheck(net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), c.FetchTimeout())
+		if err != nil {
+			res <- Result{
+				Error: "connection error",
+				Debug: err.Error(),
+			}
+			return
+		}
+		res <- Result{
+			Status: true,
+			Debug:  "responded to request",
+		}
+		return
+	}
+
+	deadline := c.deadline()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), c.FetchTimeout())
 	if err != nil {
 		res <- Result{
 			Error: "connection error",
@@ -17,8 +55,134 @@ func (c Tcp) Run(teamID uint, boxIp string, res chan Result) {
 		}
 		return
 	}
+	defer conn.Close()
+	c.exchange(conn, res, deadline)
+}
+
+func (s sendExpect) expectsResponse() bool {
+	return s.Regex != "" || s.ExpectHex != ""
+}
+
+// exchange sends the payload and checks the response. Stream
+// connections are read until the response matches, ReadBytes are
+// read, or the deadline hits. Packet connections read one datagram.
+func (s sendExpect) exchange(conn net.Conn, res chan Result, deadline time.Time) {
+	conn.SetDeadline(deadline)
+	_, packet := conn.(net.PacketConn)
+
+	if s.Send != "" {
+		payload := []byte(s.Send)
+		if s.SendHex {
+			var err error
+			payload, err = hex.DecodeString(s.Send)
+			if err != nil {
+				res <- Result{
+					Error: "invalid hex payload",
+					Debug: err.Error(),
+				}
+				return
+			}
+		}
+		_, err := conn.Write(payload)
+		if err != nil {
+			res <- Result{
+				Error: "error sending payload",
+				Debug: err.Error(),
+			}
+			return
+		}
+		// UDP always sends fine, so it needs some response
+		if !s.expectsResponse() && !packet {
+			res <- Result{
+				Status: true,
+				Debug:  "sent " + strconv.Itoa(len(payload)) + " bytes",
+			}
+			return
+		}
+	}
+
+	var re *regexp.Regexp
+	if s.Regex != "" {
+		re = regexp.MustCompile(s.Regex)
+	}
+	expect, _ := hex.DecodeString(s.ExpectHex)
+	matches := func(response []byte) bool {
+		if re != nil && !re.Match(response) {
+			return false
+		}
+		return bytes.Contains(response, expect)
+	}
+
+	response := []byte{}
+	buf := make([]byte, s.ReadBytes)
+	var err error
+	for len(response) < s.ReadBytes {
+		var n int
+		n, err = conn.Read(buf[:s.ReadBytes-len(response)])
+		response = append(response, buf[:n]...)
+		if err != nil || packet || matches(response) {
+			break
+		}
+	}
+
+	if len(response) == 0 || !matches(response) {
+		debug := "response was " + s.format(response)
+		if err != nil {
+			debug += ", error: " + err.Error()
+		}
+		res <- Result{
+			Error: "response did not match",
+			Debug: debug,
+		}
+		return
+	}
+
 	res <- Result{
 		Status: true,
-		Debug:  "responded to request",
+		Debug:  "response was " + s.format(response),
+	}
+}
+
+// format shows the response as hex if the check deals in
+// hex, otherwise as a quoted string.
+func (s sendExpect) format(response []byte) string {
+	if s.SendHex || s.ExpectHex != "" {
+		return hex.EncodeToString(response)
+	}
+	return strconv.Quote(string(response))
+}
+
+// Validate checks the payload and patterns, and sets
+// the default read size.
+func (s *sendExpect) Validate() error {
+	if s.SendHex {
+		if _, err := hex.DecodeString(s.Send); err != nil {
+			return errors.New("invalid hex for send: " + err.Error())
+		}
+	}
+	if _, err := hex.DecodeString(s.ExpectHex); err != nil {
+		return errors.New("invalid hex for expecthex: " + err.Error())
+	}
+	if s.Regex != "" {
+		if _, err := regexp.Compile(s.Regex); err != nil {
+			return err
+		}
+	}
+	if s.ReadBytes < 0 {
+		return errors.New("readbytes can't be negative")
+	}
+	if s.ReadBytes == 0 {
+		s.ReadBytes = 1024
+	}
+	return nil
+}
+
+func (c *Tcp) Validate() error {
+	if c.Port == 0 {
+		return errors.New("tcp port required")
+	}
+	if err := c.sendExpect.Validate(); err != nil {
+		return errors.New("tcp check " + c.Name + ": " + err.Error())
 	}
+	return nil
 }
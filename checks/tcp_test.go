@@ -0,0 +1,142 @@
+package checks
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendExpectExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        sendExpect
+		sent     string   // what the server should read
+		response []string // written one at a time
+		status   bool
+		debug    string
+	}{
+		{
+			name:   "send only",
+			s:      sendExpect{Send: "ping"},
+			sent:   "ping",
+			status: true,
+			debug:  "sent 4 bytes",
+		},
+		{
+			name:     "banner regex",
+			s:        sendExpect{Regex: `^220 .*ready`},
+			response: []string{"220 mail ready\r\n"},
+			status:   true,
+			debug:    `response was "220 mail ready\r\n"`,
+		},
+		{
+			name:     "regex across reads",
+			s:        sendExpect{Send: "HELO\r\n", Regex: "ready"},
+			sent:     "HELO\r\n",
+			response: []string{"250 mail ", "ready\r\n"},
+			status:   true,
+		},
+		{
+			name:     "hex payload and response",
+			s:        sendExpect{Send: "deadbeef", SendHex: true, ExpectHex: "cafe"},
+			sent:     "\xde\xad\xbe\xef",
+			response: []string{"\x00\xca\xfe\x00"},
+			status:   true,
+			debug:    "response was 00cafe00",
+		},
+		{
+			name:     "regex and hex must both match",
+			s:        sendExpect{Regex: "ok", ExpectHex: "ff"},
+			response: []string{"ok"},
+			debug:    "response was 6f6b, error: EOF",
+		},
+		{
+			name:     "no match",
+			s:        sendExpect{Regex: "^SSH-"},
+			response: []string{"HTTP/1.1 400 Bad Request\r\n"},
+			debug:    `response was "HTTP/1.1 400 Bad Request\r\n", error: EOF`,
+		},
+		{
+			name:     "read limit",
+			s:        sendExpect{Regex: "world", ReadBytes: 4},
+			response: []string{"hello world"},
+			debug:    `response was "hell"`,
+		},
+		{
+			name:  "no response",
+			s:     sendExpect{Regex: "."},
+			debug: `response was "", error: EOF`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt // used by the server goroutine
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				if tt.sent != "" {
+					sent := make([]byte, len(tt.sent))
+					if _, err := io.ReadFull(server, sent); err != nil || string(sent) != tt.sent {
+						return
+					}
+				}
+				for _, r := range tt.response {
+					if _, err := server.Write([]byte(r)); err != nil {
+						return
+					}
+				}
+			}()
+
+			res := make(chan Result, 1)
+			tt.s.exchange(client, res, time.Now().Add(5*time.Second))
+			result := <-res
+			if result.Status != tt.status {
+				t.Fatalf("got status %v (%s: %s), want %v", result.Status, result.Error, result.Debug, tt.status)
+			}
+			if tt.debug != "" && result.Debug != tt.debug {
+				t.Errorf("got debug %s, want %s", result.Debug, tt.debug)
+			}
+		})
+	}
+}
+
+func TestSendExpectExchangeUdp(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no udp on loopback:", err)
+	}
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 64)
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil || !strings.HasPrefix(string(buf[:n]), "status") {
+			return
+		}
+		// Only the first datagram is read
+		server.WriteTo([]byte("up"), addr)
+		server.WriteTo([]byte("down"), addr)
+	}()
+
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := sendExpect{Send: "status", Regex: "^up$"}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := make(chan Result, 1)
+	s.exchange(conn, res, time.Now().Add(5*time.Second))
+	if result := <-res; !result.Status {
+		t.Errorf("got %s: %s", result.Error, result.Debug)
+	}
+}
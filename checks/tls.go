@@ -0,0 +1,251 @@
+package checks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Tls struct {
+	CheckBase
+	Policy string
+}
+
+func init() {
+	Register("tls", Definition{Check: Tls{}, Port: 443, Anonymous: true})
+}
+
+// TlsPolicy is a named set of requirements for a TLS server. The
+// Tls check enforces all of them. Web, Imap and Smtp checks can
+// reference a policy too, which checks the certificate and the
+// negotiated version of the connection they make.
+type TlsPolicy struct {
+	Name             string
+	CaFile           string // CA bundle in checkfiles/ the chain must verify against
+	Hostname         string // name the certificate must be valid for
+	ExpiryDays       int    // fail if the certificate expires within this many days
+	MinVersion       string // minimum protocol version (1.0, 1.1, 1.2, 1.3)
+	AllowWeakCiphers bool
+}
+
+// Global list of all TLS policies
+var TlsPolicies []TlsPolicy
+
+var TlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// weakCipherSuites are the RC4 and 3DES suites that still show up
+// on old or misconfigured servers.
+var weakCipherSuites = []uint16{
+	tls.TLS_RSA_WITH_RC4_128_SHA,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+}
+
+func (c Tls) Run(teamID uint, boxIp string, res chan Result) {
+	policy := findTlsPolicy(c.Policy)
+	addr := net.JoinHostPort(boxIp, strconv.Itoa(c.Port))
+
+	// Connect accepting anything, so we can report what the server offers
+	state, err := tlsHandshake(addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	}, c.FetchTimeout())
+	if err != nil {
+		res <- Result{
+			Error: "tls handshake failed",
+			Debug: err.Error(),
+		}
+		return
+	}
+	debug := tlsStateDebug(state)
+
+	err = policy.verify(state)
+	if err != nil {
+		res <- Result{
+			Error: err.Error(),
+			Debug: debug,
+		}
+		return
+	}
+
+	// See if the server will go below the minimum version
+	if minVersion, ok := TlsVersions[policy.MinVersion]; ok && minVersion > tls.VersionTLS10 {
+		_, err = tlsHandshake(addr, &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS10,
+			MaxVersion:         minVersion - 1,
+		}, c.FetchTimeout())
+		if err == nil {
+			res <- Result{
+				Error: "server accepts protocol versions below TLS " + policy.MinVersion,
+				Debug: debug,
+			}
+			return
+		}
+	}
+
+	// See if the server will negotiate a weak cipher suite
+	if !policy.AllowWeakCiphers {
+		weakState, err := tlsHandshake(addr, &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS10,
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       weakCipherSuites,
+		}, c.FetchTimeout())
+		if err == nil {
+			res <- Result{
+				Error: "server accepts weak cipher " + tls.CipherSuiteName(weakState.CipherSuite),
+				Debug: debug,
+			}
+			return
+		}
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  debug,
+	}
+}
+
+// verify checks the certificate and negotiated version of an
+// established connection against the policy.
+func (p TlsPolicy) verify(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no certificate presented")
+	}
+	cert := state.PeerCertificates[0]
+
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return errors.New("certificate expired on " + cert.NotAfter.Format("2006-01-02"))
+	}
+	if now.Before(cert.NotBefore) {
+		return errors.New("certificate not valid until " + cert.NotBefore.Format("2006-01-02"))
+	}
+	if p.ExpiryDays > 0 && cert.NotAfter.Before(now.AddDate(0, 0, p.ExpiryDays)) {
+		return errors.New("certificate expires within " + strconv.Itoa(p.ExpiryDays) + " days")
+	}
+
+	if p.Hostname != "" {
+		if err := cert.VerifyHostname(p.Hostname); err != nil {
+			return errors.New("certificate not valid for " + p.Hostname)
+		}
+	}
+
+	if p.CaFile != "" {
+		bundle, err := GetFile(p.CaFile)
+		if err != nil {
+			return errors.New("could not read ca file " + p.CaFile + ": " + err.Error())
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM([]byte(bundle))
+		intermediates := x509.NewCertPool()
+		for _, c := range state.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return errors.New("certificate chain invalid: " + err.Error())
+		}
+	}
+
+	if minVersion, ok := TlsVersions[p.MinVersion]; ok && state.Version < minVersion {
+		return errors.New("negotiated " + tlsVersionName(state.Version) + ", wanted at least TLS " + p.MinVersion)
+	}
+	return nil
+}
+
+// tlsPolicyConfig returns the client config for checks that can
+// reference a TLS policy. Certificates are checked by the policy
+// instead of the usual verification, and if there's no policy
+// anything is accepted.
+func tlsPolicyConfig(name string) *tls.Config {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if name != "" {
+		policy := findTlsPolicy(name)
+		tlsConfig.MinVersion = tls.VersionTLS10
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if err := policy.verify(state); err != nil {
+				return errors.New("tls policy " + name + ": " + err.Error())
+			}
+			return nil
+		}
+	}
+	return tlsConfig
+}
+
+// checkTlsPolicy makes sure a check references a
+// policy that exists.
+func checkTlsPolicy(name string) error {
+	for _, p := range TlsPolicies {
+		if p.Name == name {
+			return nil
+		}
+	}
+	return errors.New("tls policy " + name + " not found")
+}
+
+func findTlsPolicy(name string) TlsPolicy {
+	for _, p := range TlsPolicies {
+		if p.Name == name {
+			return p
+		}
+	}
+	return TlsPolicy{}
+}
+
+func tlsHandshake(addr string, tlsConfig *tls.Config, timeout time.Duration) (tls.ConnectionState, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+	}
+	conn, err := tls.DialWithDialer(&dialer, "tcp", addr, tlsConfig)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState(), nil
+}
+
+func tlsStateDebug(state tls.ConnectionState) string {
+	debug := []string{tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		days := int(time.Until(cert.NotAfter).Hours() / 24)
+		debug = append(debug, "subject "+cert.Subject.String(), "issuer "+cert.Issuer.String(), "expires "+cert.NotAfter.Format("2006-01-02")+" ("+strconv.Itoa(days)+" days)")
+	}
+	return strings.Join(debug, ", ")
+}
+
+func tlsVersionName(version uint16) string {
+	for name, v := range TlsVersions {
+		if v == version {
+			return "TLS " + name
+		}
+	}
+	return "unknown version " + strconv.Itoa(int(version))
+}
+
+func (c *Tls) Validate() error {
+	if c.Policy != "" {
+		if err := checkTlsPolicy(c.Policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
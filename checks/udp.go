@@ -0,0 +1,43 @@
+package checks
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+type Udp struct {
+	CheckBase
+	sendExpect
+}
+
+func init() {
+	Register("udp", Definition{Check: Udp{}, Anonymous: true})
+}
+
+func (c Udp) Run(teamID uint, boxIp string, res chan Result) {
+	deadline := c.deadline()
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)), c.FetchTimeout())
+	if err != nil {
+		res <- Result{
+			Error: "connection error",
+			Debug: err.Error(),
+		}
+		return
+	}
+	defer conn.Close()
+	c.exchange(conn, res, deadline)
+}
+
+func (c *Udp) Validate() error {
+	if c.Port == 0 {
+		return errors.New("udp port required")
+	}
+	if c.Send == "" {
+		return errors.New("udp check " + c.Name + " needs a payload to send")
+	}
+	if err := c.sendExpect.Validate(); err != nil {
+		return errors.New("udp check " + c.Name + ": " + err.Error())
+	}
+	return nil
+}
@@ -1,50 +1,230 @@
 package checks
 
 import (
-	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/mitchellh/go-vnc"
 )
 
 type Vnc struct {
-	checkBase
+	CheckBase
+	VeNCrypt    bool // log in with username and password instead of VNC auth
+	Framebuffer bool // request a framebuffer update after logging in
+}
+
+func init() {
+	Register("vnc", Definition{Check: Vnc{}, Port: 5900})
+}
+
+// VeNCrypt subtypes we can do. TLSPlain needs anonymous
+// Diffie-Hellman, which Go's TLS doesn't support.
+const (
+	vencryptPlain     = 256
+	vencryptX509Plain = 262
+)
+
+// vncConn lets VeNCrypt swap the connection for a TLS one
+// in the middle of the handshake.
+type vncConn struct {
+	net.Conn
+}
+
+// vencryptAuth is VeNCrypt (security type 19) with the
+// Plain or X509Plain subtypes.
+type vencryptAuth struct {
+	Username string
+	Password string
+	subtype  uint32
+}
+
+func (a *vencryptAuth) SecurityType() uint8 {
+	return 19
+}
+
+func (a *vencryptAuth) Handshake(c net.Conn) error {
+	// Agree on version 0.2
+	version := make([]byte, 2)
+	if _, err := io.ReadFull(c, version); err != nil {
+		return err
+	}
+	if version[0] != 0 || version[1] < 2 {
+		return fmt.Errorf("unsupported vencrypt version %d.%d", version[0], version[1])
+	}
+	if _, err := c.Write([]byte{0, 2}); err != nil {
+		return err
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(c, status); err != nil {
+		return err
+	}
+	if status[0] != 0 {
+		return errors.New("server rejected vencrypt version 0.2")
+	}
+
+	// Pick a subtype, preferring TLS
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(c, count); err != nil {
+		return err
+	}
+	subtypes := make([]uint32, count[0])
+	if err := binary.Read(c, binary.BigEndian, &subtypes); err != nil {
+		return err
+	}
+	for _, want := range []uint32{vencryptX509Plain, vencryptPlain} {
+		for _, subtype := range subtypes {
+			if subtype == want {
+				a.subtype = subtype
+				break
+			}
+		}
+		if a.subtype != 0 {
+			break
+		}
+	}
+	if a.subtype == 0 {
+		return fmt.Errorf("no supported vencrypt subtypes, server offered %v", subtypes)
+	}
+	if err := binary.Write(c, binary.BigEndian, a.subtype); err != nil {
+		return err
+	}
+
+	if a.subtype == vencryptX509Plain {
+		if _, err := io.ReadFull(c, status); err != nil {
+			return err
+		}
+		if status[0] != 1 {
+			return errors.New("server rejected vencrypt subtype")
+		}
+		wrapped, ok := c.(*vncConn)
+		if !ok {
+			return errors.New("connection can't be upgraded to tls")
+		}
+		tlsConn := tls.Client(wrapped.Conn, &tls.Config{
+			InsecureSkipVerify: true,
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		wrapped.Conn = tlsConn
+	}
+
+	lengths := []uint32{uint32(len(a.Username)), uint32(len(a.Password))}
+	if err := binary.Write(c, binary.BigEndian, lengths); err != nil {
+		return err
+	}
+	_, err := c.Write([]byte(a.Username + a.Password))
+	return err
 }
 
 func (c Vnc) Run(teamID uint, boxIp string, res chan Result) {
 	// Configure the vnc client
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+	var auth vnc.ClientAuth
+	var vencrypt *vencryptAuth
+	credDebug := "anonymous"
+	if c.VeNCrypt {
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
+		vencrypt = &vencryptAuth{Username: username, Password: password}
+		auth = vencrypt
+		credDebug = "creds " + username + ":" + password
+	} else if c.Anonymous {
+		auth = new(vnc.ClientAuthNone)
+	} else {
+		// VNC auth only has a password
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
+		auth = &vnc.PasswordAuth{Password: password}
+		credDebug = "creds " + username + ":" + password
+	}
 	config := vnc.ClientConfig{
-		Auth: []vnc.ClientAuth{
-			&vnc.PasswordAuth{Password: password},
-		},
+		Auth: []vnc.ClientAuth{auth},
+	}
+	messages := make(chan vnc.ServerMessage, 8)
+	if c.Framebuffer {
+		config.ServerMessageCh = messages
 	}
 
-	// Dial the vnc server
-	dialer := net.Dialer{}
-	conn, err := dialer.DialContext(context.TODO(), "tcp", fmt.Sprintf("%s:%d", boxIp, c.Port))
+	// Dial the vnc server. Everything after shares one deadline,
+	// including waiting for the framebuffer.
+	deadline := c.deadline()
+	dialer := net.Dialer{
+		Deadline: deadline,
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(boxIp, strconv.Itoa(c.Port)))
 	if err != nil {
 		res <- Result{
 			Error: "connection to vnc server failed",
-			Debug: err.Error() + " for creds " + username + ":" + password,
+			Debug: err.Error() + " for " + credDebug,
 		}
 		return
 	}
 	defer conn.Close()
+	conn.SetDeadline(deadline)
 
-	vncClient, err := vnc.Client(conn, &config)
+	vncClient, err := vnc.Client(&vncConn{conn}, &config)
 	if err != nil {
 		res <- Result{
 			Error: "failed to log in to VNC server",
-			Debug: err.Error() + " for creds " + username + ":" + password,
+			Debug: err.Error() + " for " + credDebug,
 		}
 		return
 	}
 	defer vncClient.Close()
 
+	debug := "logged in to " + vncClient.DesktopName + " with " + credDebug
+	if vencrypt != nil {
+		debug += " (vencrypt subtype " + strconv.Itoa(int(vencrypt.subtype)) + ")"
+	}
+
+	if c.Framebuffer {
+		// A small corner is enough to prove the session is live
+		width, height := vncClient.FrameBufferWidth, vncClient.FrameBufferHeight
+		if width > 64 {
+			width = 64
+		}
+		if height > 64 {
+			height = 64
+		}
+		err = vncClient.FramebufferUpdateRequest(false, 0, 0, width, height)
+		if err != nil {
+			res <- Result{
+				Error: "framebuffer update request failed",
+				Debug: err.Error() + ", " + debug,
+			}
+			return
+		}
+
+		updated := false
+		timeout := time.After(time.Until(deadline))
+		for !updated {
+			select {
+			case msg := <-messages:
+				_, updated = msg.(*vnc.FramebufferUpdateMessage)
+			case <-timeout:
+				res <- Result{
+					Error: "no framebuffer update received",
+					Debug: debug,
+				}
+				return
+			}
+		}
+		debug += ", received framebuffer update"
+	}
+
 	res <- Result{
 		Status: true,
-		Debug:  "creds " + username + ":" + password,
+		Debug:  debug,
+	}
+}
+
+func (c *Vnc) Validate() error {
+	if c.VeNCrypt && c.Anonymous {
+		return errors.New("can't use anonymous with vencrypt for vnc check " + c.Name)
 	}
+	return nil
 }
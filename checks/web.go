@@ -1,18 +1,30 @@
 package checks
 
 import (
-	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type Web struct {
-	checkBase
-	Url    []urlData
-	Scheme string
+	CheckBase
+	Url       []urlData
+	Step      []webStep
+	Scheme    string
+	TlsPolicy string
+}
+
+func init() {
+	Register("web", Definition{Check: Web{}, Port: 80})
 }
 
 type urlData struct {
@@ -20,44 +32,99 @@ type urlData struct {
 	// use creds list for check for login
 	UsernameParam string
 	PasswordParam string
+	LoginPath     string
 	Status        int
 	Diff          int
 	Regex         string
-	CompareFile   string // TODO implement
+	CompareFile   string
 }
 
-func (c Web) Run(teamID uint, boxIp string, res chan Result) {
-	u := c.Url[rand.Intn(len(c.Url))]
-	// if usernameParam == nil
-	// post with username/pw as creds
-	// else
+// webStep is one request in a scripted transaction. Steps run in
+// order and share a cookie jar. Values captured from one response
+// can be used in later steps as {{name}}, as can {{username}} and
+// {{password}}.
+type webStep struct {
+	Method  string
+	Path    string
+	Form    map[string]string
+	Json    string
+	Status  int
+	Regex   string
+	Capture map[string]string // variable name to regex with one group
+}
+
+// newClient returns an http client with a cookie jar, so
+// sessions are kept from one request to the next.
+func (c Web) newClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
 	tr := &http.Transport{
 		MaxIdleConns:      1,
-		IdleConnTimeout:   GlobalTimeout,
+		IdleConnTimeout:   c.FetchTimeout(),
 		DisableKeepAlives: true,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+		TLSClientConfig:   tlsPolicyConfig(c.TlsPolicy),
+	}
+	return &http.Client{Transport: tr, Jar: jar}
+}
+
+func (c Web) Run(teamID uint, boxIp string, res chan Result) {
+	if len(c.Step) > 0 {
+		c.runSteps(teamID, boxIp, res)
+		return
+	}
+
+	u := c.Url[rand.Intn(len(c.Url))]
+	client := c.newClient()
+	baseUrl := c.Scheme + "://" + net.JoinHostPort(boxIp, strconv.Itoa(c.Port))
+
+	var resp *http.Response
+	var err error
+	credDebug := ""
+	if u.UsernameParam != "" {
+		// Post creds to the login form. If no separate login path is
+		// given, the login response is the page we check.
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
+		credDebug = " with creds " + username + ":" + password
+		form := url.Values{}
+		form.Set(u.UsernameParam, username)
+		form.Set(u.PasswordParam, password)
+
+		loginPath := u.Path
+		if u.LoginPath != "" {
+			loginPath = u.LoginPath
+		}
+		resp, err = client.PostForm(baseUrl+loginPath, form)
+		if err != nil {
+			res <- Result{
+				Error: "login request errored out",
+				Debug: err.Error() + " for url " + loginPath + credDebug,
+			}
+			return
+		}
+
+		if u.LoginPath != "" {
+			resp.Body.Close()
+			resp, err = client.Get(baseUrl + u.Path)
+		}
+	} else {
+		resp, err = client.Get(baseUrl + u.Path)
 	}
-	client := &http.Client{Transport: tr}
-	resp, err := client.Get(c.Scheme + "://" + boxIp + ":" + strconv.Itoa(c.Port) + u.Path)
 	if err != nil {
 		res <- Result{
 			Error: "web request errored out",
-			Debug: err.Error() + " for url " + u.Path,
+			Debug: err.Error() + " for url " + u.Path + credDebug,
 		}
 		return
 	}
+	defer resp.Body.Close()
 
 	if u.Status != 0 && resp.StatusCode != u.Status {
 		res <- Result{
 			Error: "status returned by webserver was incorrect",
-			Debug: "status was " + strconv.Itoa(resp.StatusCode) + " wanted " + strconv.Itoa(u.Status) + " for url " + u.Path,
+			Debug: "status was " + strconv.Itoa(resp.StatusCode) + " wanted " + strconv.Itoa(u.Status) + " for url " + u.Path + credDebug,
 		}
 		return
 	}
 
-	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		res <- Result{
@@ -67,6 +134,24 @@ func (c Web) Run(teamID uint, boxIp string, res chan Result) {
 		return
 	}
 
+	if u.CompareFile != "" {
+		diff, err := FileDifference(u.CompareFile, string(body))
+		if err != nil {
+			res <- Result{
+				Error: "error reading compare file",
+				Debug: err.Error(),
+			}
+			return
+		}
+		if diff < u.Diff {
+			res <- Result{
+				Error: "page content was too different from expected",
+				Debug: "page " + u.Path + " was " + strconv.Itoa(diff) + "% similar to " + u.CompareFile + ", wanted at least " + strconv.Itoa(u.Diff) + "%" + credDebug,
+			}
+			return
+		}
+	}
+
 	if u.Regex != "" {
 		re, err := regexp.Compile(u.Regex)
 		if err != nil {
@@ -79,15 +164,15 @@ func (c Web) Run(teamID uint, boxIp string, res chan Result) {
 		reFind := re.Find(body)
 		if reFind == nil {
 			res <- Result{
-				Error: "didn't find regex on page :(",
-				Debug: "couldn't find regex \"" + u.Regex + "\" for " + u.Path,
+				Error: "didn't find regex on page :(",
+				Debug: "couldn't find regex \"" + u.Regex + "\" for " + u.Path + credDebug,
 			}
 			return
 		} else {
 			res <- Result{
 				Status: true,
 				Error:  "page matched regex!",
-				Debug:  "matched regex \"" + u.Regex + "\" for " + u.Path,
+				Debug:  "matched regex \"" + u.Regex + "\" for " + u.Path + credDebug,
 			}
 			return
 		}
@@ -96,5 +181,206 @@ func (c Web) Run(teamID uint, boxIp string, res chan Result) {
 
 	res <- Result{
 		Status: true,
+		Debug:  "retrieved " + u.Path + credDebug,
+	}
+}
+
+func (c Web) runSteps(teamID uint, boxIp string, res chan Result) {
+	client := c.newClient()
+	baseUrl := c.Scheme + "://" + net.JoinHostPort(boxIp, strconv.Itoa(c.Port))
+
+	vars := map[string]string{}
+	credDebug := ""
+	if !c.Anonymous {
+		username, password := GetCreds(teamID, c.CredLists, c.Name)
+		vars["username"] = username
+		vars["password"] = password
+		credDebug = "creds " + username + ":" + password + "; "
+	}
+
+	// Each step adds its outcome, so teams can see where it broke
+	debug := []string{}
+	fail := func(errString string, stepDebug string) {
+		res <- Result{
+			Error: errString,
+			Debug: credDebug + strings.Join(append(debug, stepDebug), "; "),
+		}
+	}
+
+	for i, step := range c.Step {
+		path := expandVars(step.Path, vars, nil)
+		stepDebug := "step " + strconv.Itoa(i+1) + " " + step.Method + " " + path
+
+		var body io.Reader
+		contentType := ""
+		if step.Json != "" {
+			body = strings.NewReader(expandVars(step.Json, vars, jsonEscape))
+			contentType = "application/json"
+		} else if len(step.Form) > 0 {
+			form := url.Values{}
+			for k, v := range step.Form {
+				form.Set(k, expandVars(v, vars, nil))
+			}
+			body = strings.NewReader(form.Encode())
+			contentType = "application/x-www-form-urlencoded"
+		}
+
+		req, err := http.NewRequest(step.Method, baseUrl+path, body)
+		if err != nil {
+			fail("error creating request for step "+strconv.Itoa(i+1), stepDebug+": "+err.Error())
+			return
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			fail("web request errored out on step "+strconv.Itoa(i+1), stepDebug+": "+err.Error())
+			return
+		}
+		page, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			fail("error reading page content on step "+strconv.Itoa(i+1), stepDebug+": "+err.Error())
+			return
+		}
+		stepDebug += ": " + strconv.Itoa(resp.StatusCode)
+
+		if step.Status != 0 && resp.StatusCode != step.Status {
+			fail("status returned by webserver was incorrect on step "+strconv.Itoa(i+1), stepDebug+", wanted "+strconv.Itoa(step.Status))
+			return
+		}
+
+		if step.Regex != "" {
+			re, err := regexp.Compile(step.Regex)
+			if err != nil {
+				fail("error compiling regex to match for web page", stepDebug+": "+err.Error())
+				return
+			}
+			if !re.Match(page) {
+				fail("didn't find regex on page for step "+strconv.Itoa(i+1), stepDebug+", couldn't find regex \""+step.Regex+"\"")
+				return
+			}
+		}
+
+		for name, capture := range step.Capture {
+			re, err := regexp.Compile(capture)
+			if err != nil {
+				fail("error compiling capture regex", stepDebug+": "+err.Error())
+				return
+			}
+			match := re.FindSubmatch(page)
+			if len(match) < 2 {
+				fail("couldn't capture "+name+" on step "+strconv.Itoa(i+1), stepDebug+", capture regex \""+capture+"\" didn't match")
+				return
+			}
+			vars[name] = string(match[1])
+		}
+
+		debug = append(debug, stepDebug+" ok")
+	}
+
+	res <- Result{
+		Status: true,
+		Debug:  credDebug + strings.Join(debug, "; "),
+	}
+}
+
+var varPattern = regexp.MustCompile(`{{([^{}]+)}}`)
+
+// expandVars replaces {{name}} with the value of each variable, passed
+// through escape if it's set. It's done in one pass, so values that
+// contain {{name}} themselves are left alone. Unknown names are kept.
+func expandVars(input string, vars map[string]string, escape func(string) string) string {
+	return varPattern.ReplaceAllStringFunc(input, func(match string) string {
+		value, ok := vars[match[2:len(match)-2]]
+		if !ok {
+			return match
+		}
+		if escape != nil {
+			value = escape(value)
+		}
+		return value
+	})
+}
+
+// jsonEscape escapes a value to go inside a JSON string.
+func jsonEscape(value string) string {
+	escaped, _ := json.Marshal(value)
+	return string(escaped[1 : len(escaped)-1])
+}
+
+func (c *Web) Validate() error {
+	if len(c.Url) == 0 && len(c.Step) == 0 {
+		return errors.New("no urls or steps specified for web check " + c.Name)
+	}
+	if len(c.Url) != 0 && len(c.Step) != 0 {
+		return errors.New("cannot use both urls and steps for web check " + c.Name)
+	}
+	if c.Scheme == "" {
+		c.Scheme = "http"
+	}
+	if c.TlsPolicy != "" {
+		if c.Scheme != "https" {
+			return errors.New("tls policy needs https for web check " + c.Name)
+		}
+		if err := checkTlsPolicy(c.TlsPolicy); err != nil {
+			return err
+		}
+	}
+	login := false
+	for _, u := range c.Url {
+		if u.Diff != 0 && u.CompareFile == "" {
+			return errors.New("need compare file for diff in web")
+		}
+		if u.CompareFile != "" {
+			if u.Diff < 1 || u.Diff > 100 {
+				return errors.New("need diff between 1 and 100 for compare file in web")
+			}
+			if _, err := GetFile(u.CompareFile); err != nil {
+				return errors.New("can't read compare file for web check " + c.Name + ": " + err.Error())
+			}
+		}
+		if (u.UsernameParam == "") != (u.PasswordParam == "") {
+			return errors.New("need both usernameparam and passwordparam for web login")
+		}
+		if u.LoginPath != "" && u.UsernameParam == "" {
+			return errors.New("need usernameparam and passwordparam for web loginpath")
+		}
+		if u.UsernameParam != "" {
+			login = true
+		}
+	}
+	for k, step := range c.Step {
+		if step.Method == "" {
+			c.Step[k].Method = "GET"
+		} else {
+			c.Step[k].Method = strings.ToUpper(step.Method)
+		}
+		if step.Json != "" && len(step.Form) != 0 {
+			return errors.New("cannot use both form and json for web step")
+		}
+		if step.Regex != "" {
+			regexp.MustCompile(step.Regex)
+		}
+		for name, capture := range step.Capture {
+			if regexp.MustCompile(capture).NumSubexp() < 1 {
+				return errors.New("web capture regex for " + name + " needs a group")
+			}
+		}
+		usesCreds := strings.Contains(step.Path+step.Json, "{{username}}") || strings.Contains(step.Path+step.Json, "{{password}}")
+		for _, v := range step.Form {
+			if strings.Contains(v, "{{username}}") || strings.Contains(v, "{{password}}") {
+				usesCreds = true
+			}
+		}
+		if usesCreds {
+			login = true
+		}
+	}
+	if len(c.CredLists) < 1 && !login {
+		c.Anonymous = true
 	}
+	return nil
 }
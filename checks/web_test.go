@@ -0,0 +1,49 @@
+package checks
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"username": "robin",
+		"password": `p"ss\word`,
+		"token":    "{{password}}",
+		"csrf-id":  "abc",
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		escape func(string) string
+		want   string
+	}{
+		{"path", "/users/{{username}}/edit", nil, "/users/robin/edit"},
+		{"repeated", "{{username}}{{username}}", nil, "robinrobin"},
+		{"unknown kept", "{{username}} {{nope}}", nil, "robin {{nope}}"},
+		{"other names", "id={{csrf-id}}", nil, "id=abc"},
+		{"not expanded twice", "{{token}}", nil, "{{password}}"},
+		{"json", `{"user": "{{username}}", "pass": "{{password}}"}`, jsonEscape, `{"user": "robin", "pass": "p\"ss\\word"}`},
+		{"json control characters", `"{{token}}"`, func(v string) string { return jsonEscape(v + "\n") }, `"{{password}}\n"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandVars(tt.input, vars, tt.escape)
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The filled in body is still valid JSON with the original values
+	body := expandVars(`{"pass": "{{password}}"}`, vars, jsonEscape)
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["pass"] != vars["password"] {
+		t.Errorf("decoded %q, want %q", decoded["pass"], vars["password"])
+	}
+}
@@ -2,6 +2,7 @@ package checks
 
 import (
 	"bytes"
+	"errors"
 	"math/rand"
 	"regexp"
 	"strings"
@@ -11,12 +12,16 @@ import (
 )
 
 type WinRM struct {
-	checkBase
+	CheckBase
 	Encrypted   bool
 	BadAttempts int
 	Command     []winCommandData
 }
 
+func init() {
+	Register("winrm", Definition{Check: WinRM{}})
+}
+
 type winCommandData struct {
 	UseRegex bool
 	Contains bool
@@ -25,17 +30,23 @@ type winCommandData struct {
 }
 
 func (c WinRM) Run(teamID uint, boxIp string, res chan Result) {
-	username, password := getCreds(teamID, c.CredLists, c.Name)
+	username, password := GetCreds(teamID, c.CredLists, c.Name)
 	params := *winrm.DefaultParameters
 
+	// The endpoint url is built as host:port
+	host := boxIp
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
 	// Run bad attempts if specified
 	for i := 0; i < c.BadAttempts; i++ {
-		endpoint := winrm.NewEndpoint(boxIp, c.Port, c.Encrypted, true, nil, nil, nil, GlobalTimeout)
+		endpoint := winrm.NewEndpoint(host, c.Port, c.Encrypted, true, nil, nil, nil, c.FetchTimeout())
 		winrm.NewClientWithParameters(endpoint, username, uuid.New().String(), &params)
 	}
 
 	// Log in to WinRM
-	endpoint := winrm.NewEndpoint(boxIp, c.Port, c.Encrypted, true, nil, nil, nil, GlobalTimeout)
+	endpoint := winrm.NewEndpoint(host, c.Port, c.Encrypted, true, nil, nil, nil, c.FetchTimeout())
 	client, err := winrm.NewClientWithParameters(endpoint, username, password, &params)
 	if err != nil {
 		res <- Result{
@@ -114,3 +125,25 @@ func (c WinRM) Run(teamID uint, boxIp string, res chan Result) {
 		Debug:  "creds used were " + username + ":" + password,
 	}
 }
+
+func (c *WinRM) Validate() error {
+	if c.Port == 0 {
+		if c.Encrypted {
+			c.Port = 443
+		} else {
+			c.Port = 80
+		}
+	}
+	if c.Anonymous {
+		return errors.New("anonymous winrm not supported")
+	}
+	for _, r := range c.Command {
+		if r.UseRegex {
+			regexp.MustCompile(r.Output)
+		}
+		if r.UseRegex && r.Contains {
+			return errors.New("cannot use both regex and contains")
+		}
+	}
+	return nil
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"reflect"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -44,10 +44,15 @@ type config struct {
 	Timeout      int
 	SlaThreshold int
 
-	// Points per service check.
+	// Default points per service check, checks can override it.
 	ServicePoints int
 	SlaPoints     int
 
+	// How checks blocked by a down dependency are scored: strict
+	// (like any down check), nosla (no points, no SLA counter),
+	// or forgive (counts as up for points and uptime, no SLA counter).
+	DependencyPolicy string
+
 	Admin   []TeamData
 	Red     []TeamData
 	Team    []TeamData
@@ -55,6 +60,9 @@ type config struct {
 	Creds   []checks.CredData
 	Running bool
 
+	// Named TLS requirements for tls checks (and web, imap, smtp)
+	TlsPolicy []checks.TlsPolicy
+
 	// Inject API key
 	InjectAPIKey string
 	DBPath       string
@@ -63,101 +71,130 @@ type config struct {
 type Box struct {
 	Name string
 	IP   string
+	IP6  string // ipv6 address, for dual-stack or ipv6-only boxes
 
 	// Only used for delayed check
 	Time time.Time `gorm:"-"`
 
-	CheckList []checks.Check
-	Cmd       []checks.Cmd
-	Dns       []checks.Dns
-	Ftp       []checks.Ftp
-	Imap      []checks.Imap
-	Irc       []checks.Irc
-	Ldap      []checks.Ldap
-	Ping      []checks.Ping
-	Rdp       []checks.Rdp
-	Smb       []checks.Smb
-	Smtp      []checks.Smtp
-	Sql       []checks.Sql
-	Ssh       []checks.Ssh
-	Tcp       []checks.Tcp
-	Vnc       []checks.Vnc
-	Web       []checks.Web
-	WinRM     []checks.WinRM
+	CheckList []checks.Check `toml:"-"`
 }
 
 func (b Box) InjectTime() time.Time {
 	return startTime.Add(b.Time.Sub(ZeroTime)).In(loc)
 }
 
-func getBoxChecks(b Box) []checks.Check {
-	// Please forgive me
-	checkList := []checks.Check{}
-	for _, c := range b.Cmd {
-		checkList = append(checkList, c)
-	}
-	for _, c := range b.Dns {
-		checkList = append(checkList, c)
-	}
-	for _, c := range b.Ftp {
-		checkList = append(checkList, c)
-	}
-	for _, c := range b.Imap {
-		checkList = append(checkList, c)
+// checkIP is the address a check should use. Checks use ip6
+// when they ask for it, or when the box has no ipv4 address.
+func (b Box) checkIP(ipv6 bool) string {
+	if ipv6 || b.IP == "" {
+		return b.IP6
 	}
-	for _, c := range b.Irc {
-		checkList = append(checkList, c)
-	}
-	for _, c := range b.Ping {
-		checkList = append(checkList, c)
-	}
-	for _, c := range b.Ldap {
-		checkList = append(checkList, c)
+	return b.IP
+}
+
+func readConfig(conf *config) {
+	fileContent, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		log.Fatalln("Configuration file ("+*configPath+") not found:", err)
 	}
-	for _, c := range b.Rdp {
-		checkList = append(checkList, c)
+	md, err := toml.Decode(string(fileContent), &conf)
+	if err != nil {
+		log.Fatalln(err)
 	}
-	for _, c := range b.Smb {
-		checkList = append(checkList, c)
+	undecodedKeys, err := decodeChecks(md, string(fileContent), conf.Box)
+	if err != nil {
+		log.Fatalln(err)
 	}
-	for _, c := range b.Smtp {
-		checkList = append(checkList, c)
+	for _, undecoded := range undecodedKeys {
+		errMsg := "[WARN] Undecoded scoring configuration key \"" + undecoded.String() + "\" will not be used."
+		configErrors = append(configErrors, errMsg)
+		log.Println(errMsg)
 	}
-	for _, c := range b.Sql {
-		checkList = append(checkList, c)
+}
+
+// decodeChecks decodes the checks for each box, using the check type
+// registered for each key (ex. [[box.ssh]]). md is from decoding the
+// rest of the config, and the keys neither decode used are returned.
+func decodeChecks(md toml.MetaData, content string, boxes []Box) ([]toml.Key, error) {
+	var raw struct {
+		Box []map[string]toml.Primitive
 	}
-	for _, c := range b.Ssh {
-		checkList = append(checkList, c)
+	rawMd, err := toml.Decode(content, &raw)
+	if err != nil {
+		return nil, err
 	}
-	for _, c := range b.Tcp {
-		checkList = append(checkList, c)
+	for i, fields := range raw.Box {
+		boxes[i].CheckList = []checks.Check{}
+		for _, key := range checks.Registered() {
+			for field, value := range fields {
+				if !strings.EqualFold(field, key) {
+					continue
+				}
+				def, _ := checks.Lookup(key)
+				list := reflect.New(reflect.SliceOf(reflect.TypeOf(def.Check)))
+				if err := rawMd.PrimitiveDecode(value, list.Interface()); err != nil {
+					return nil, errors.New("box " + boxes[i].Name + " " + field + " checks: " + err.Error())
+				}
+				for j := 0; j < list.Elem().Len(); j++ {
+					boxes[i].CheckList = append(boxes[i].CheckList, list.Elem().Index(j).Interface().(checks.Check))
+				}
+			}
+		}
 	}
-	for _, c := range b.Vnc {
-		checkList = append(checkList, c)
+
+	// Box keys are only undecoded if the check decoding didn't use them.
+	// Both decodes can see the same unknown box key, so report it once.
+	undecoded := []toml.Key{}
+	seen := make(map[string]bool)
+	add := func(key toml.Key) {
+		if !seen[key.String()] {
+			seen[key.String()] = true
+			undecoded = append(undecoded, key)
+		}
 	}
-	for _, c := range b.Web {
-		checkList = append(checkList, c)
+	for _, key := range md.Undecoded() {
+		if !strings.EqualFold(key[0], "box") {
+			add(key)
+		} else if _, ok := checks.Lookup(key[1]); len(key) == 2 && !ok {
+			add(key)
+		}
 	}
-	for _, c := range b.WinRM {
-		checkList = append(checkList, c)
+	for _, key := range rawMd.Undecoded() {
+		if strings.EqualFold(key[0], "box") {
+			add(key)
+		}
 	}
-	return checkList
+	return undecoded, nil
 }
 
-func readConfig(conf *config) {
-	fileContent, err := ioutil.ReadFile(*configPath)
-	if err != nil {
-		log.Fatalln("Configuration file ("+*configPath+") not found:", err)
-	}
-	if md, err := toml.Decode(string(fileContent), &conf); err != nil {
-		log.Fatalln(err)
-	} else {
-		for _, undecoded := range md.Undecoded() {
-			errMsg := "[WARN] Undecoded scoring configuration key \"" + undecoded.String() + "\" will not be used."
-			configErrors = append(configErrors, errMsg)
-			log.Println(errMsg)
+// encodeSettings writes the config as TOML, with each box's checks
+// grouped under their config keys (ex. [[Box.ssh]]) like the config file.
+func encodeSettings(w io.Writer, conf *config) error {
+	boxes := []map[string]interface{}{}
+	for _, b := range conf.Box {
+		box := map[string]interface{}{
+			"Name": b.Name,
+			"IP":   b.IP,
+		}
+		if b.IP6 != "" {
+			box["IP6"] = b.IP6
+		}
+		if !b.Time.IsZero() {
+			box["Time"] = b.Time
 		}
+		for _, c := range b.CheckList {
+			key := checks.Key(c)
+			list, _ := box[key].([]checks.Check)
+			box[key] = append(list, c)
+		}
+		boxes = append(boxes, box)
 	}
+	rest := *conf
+	rest.Box = nil
+	if err := toml.NewEncoder(w).Encode(rest); err != nil {
+		return err
+	}
+	return toml.NewEncoder(w).Encode(map[string]interface{}{"Box": boxes})
 }
 
 func checkConfig(conf *config) error {
@@ -244,6 +281,7 @@ func checkConfig(conf *config) error {
 	if conf.ServicePoints == 0 {
 		conf.ServicePoints = 3
 	}
+	checks.DefaultPoints = conf.ServicePoints
 
 	if conf.SlaThreshold == 0 {
 		conf.SlaThreshold = 6
@@ -253,9 +291,17 @@ func checkConfig(conf *config) error {
 		conf.SlaPoints = conf.SlaThreshold * 2
 	}
 
+	switch conf.DependencyPolicy {
+	case "":
+		conf.DependencyPolicy = "strict"
+	case "strict", "nosla", "forgive":
+	default:
+		return errors.New("illegal config: dependency policy must be strict, nosla, or forgive")
+	}
+
 	// sort boxes
 	sort.SliceStable(conf.Box, func(i, j int) bool {
-		return conf.Box[i].IP < conf.Box[j].IP
+		return conf.Box[i].checkIP(false) < conf.Box[j].checkIP(false)
 	})
 
 	// check for duplicate boxes
@@ -337,6 +383,29 @@ func checkConfig(conf *config) error {
 		})
 	}
 
+	policyNames := map[string]bool{}
+	for _, p := range conf.TlsPolicy {
+		if p.Name == "" {
+			return errors.New("illegal config: tls policy missing name")
+		}
+		if policyNames[p.Name] {
+			return errors.New("illegal config: duplicate tls policy " + p.Name)
+		}
+		policyNames[p.Name] = true
+		if _, ok := checks.TlsVersions[p.MinVersion]; p.MinVersion != "" && !ok {
+			return errors.New("illegal config: invalid minversion for tls policy " + p.Name + " (must be 1.0, 1.1, 1.2, or 1.3)")
+		}
+		if p.ExpiryDays < 0 {
+			return errors.New("illegal config: expirydays can't be negative for tls policy " + p.Name)
+		}
+		if p.CaFile != "" {
+			if _, err := checks.GetFile(p.CaFile); err != nil {
+				return errors.New("illegal config: can't read ca file for tls policy " + p.Name + ": " + err.Error())
+			}
+		}
+	}
+	checks.TlsPolicies = conf.TlsPolicy
+
 	err := validateChecks(conf.Box)
 	if err != nil {
 		return err
@@ -351,306 +420,111 @@ func checkConfig(conf *config) error {
 		}
 	}
 
+	err = checkDependencies(conf.Box, time.Duration(conf.Delay-conf.Jitter)*time.Second)
+	if err != nil {
+		return err
+	}
+
 	checks.CredLists = dwConf.Creds
 
 	return nil
 }
 
 func validateChecks(boxList []Box) error {
-	// check validators
-	// please overlook this transgression
 	for i, b := range boxList {
-		boxList[i].CheckList = getBoxChecks(b)
-		if b.IP == "" {
+		if b.IP == "" && b.IP6 == "" {
 			return errors.New("illegal config: no ip found for box " + b.Name)
 		}
 		// Ensure IP replacement chars are lowercase
 		b.IP = strings.ToLower(b.IP)
 		boxList[i].IP = b.IP
+		b.IP6 = strings.ToLower(b.IP6)
+		boxList[i].IP6 = b.IP6
+		if b.IP6 != "" && !strings.Contains(b.IP6, ":") {
+			return errors.New("illegal config: ip6 for box " + b.Name + " is not an ipv6 address")
+		}
 		for j, c := range boxList[i].CheckList {
-			switch c.(type) {
-			case checks.Cmd:
-				ck := c.(checks.Cmd)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "cmd"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if len(ck.CredLists) < 1 && !strings.Contains(ck.Command, "USERNAME") && !strings.Contains(ck.Command, "PASSWORD") {
-					ck.Anonymous = true
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Dns:
-				ck := c.(checks.Dns)
-				ck.IP = b.IP
-				ck.Anonymous = true // call me when you need authed DNS
-				if ck.Display == "" {
-					ck.Display = "dns"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if len(ck.Record) < 1 {
-					return errors.New("dns check " + ck.Name + " has no records")
-				}
-				if ck.Port == 0 {
-					ck.Port = 53
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Ftp:
-				ck := c.(checks.Ftp)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "ftp"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 21
-				}
-				for _, f := range ck.File {
-					if f.Regex != "" && f.Hash != "" {
-						return errors.New("can't have both regex and hash for ftp file check")
-					}
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Imap:
-				ck := c.(checks.Imap)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "imap"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 143
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Irc:
-				ck := c.(checks.Irc)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "irc"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 6667
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Ldap:
-				ck := c.(checks.Ldap)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "ldap"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 636
-				}
-				if ck.Anonymous {
-					return errors.New("anonymous ldap not supported")
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Ping:
-				ck := c.(checks.Ping)
-				ck.IP = b.IP
-				ck.Anonymous = true
-				if ck.Count == 0 {
-					ck.Count = 1
-				}
-				if ck.Display == "" {
-					ck.Display = "ping"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Rdp:
-				ck := c.(checks.Rdp)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "rdp"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 3389
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Smb:
-				ck := c.(checks.Smb)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "smb"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 445
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Smtp:
-				ck := c.(checks.Smtp)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "smtp"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 25
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Sql:
-				ck := c.(checks.Sql)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "sql"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Kind == "" {
-					ck.Kind = "mysql"
-				}
-				if ck.Port == 0 {
-					ck.Port = 3306
-				}
-				for _, q := range ck.Query {
-					if q.DatabaseExists && (q.Column != "" || q.Table != "" || q.Output != "") {
-						return errors.New("cannot use both database exists check and row check")
-					}
-					if q.DatabaseExists && q.Database == "" {
-						return errors.New("must specify database for database exists check")
-					}
-					if q.UseRegex {
-						regexp.MustCompile(q.Output)
-					}
-					if q.UseRegex && q.Contains {
-						return errors.New("cannot use both regex and contains")
-					}
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Ssh:
-				ck := c.(checks.Ssh)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "ssh"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 22
-				}
-				if ck.PrivKey != "" && ck.BadAttempts != 0 {
-					return errors.New("can not have bad attempts with pubkey for ssh")
-				}
-				for _, r := range ck.Command {
-					if r.UseRegex {
-						regexp.MustCompile(r.Output)
-					}
-					if r.UseRegex && r.Contains {
-						return errors.New("cannot use both regex and contains")
-					}
-				}
-				if ck.Anonymous {
-					return errors.New("anonymous ssh not supported")
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Tcp:
-				ck := c.(checks.Tcp)
-				ck.IP = b.IP
-				ck.Anonymous = true
-				if ck.Display == "" {
-					ck.Display = "tcp"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					return errors.New("tcp port required")
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Vnc:
-				ck := c.(checks.Vnc)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "vnc"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 5900
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.Web:
-				ck := c.(checks.Web)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "web"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					ck.Port = 80
-				}
-				if len(ck.Url) == 0 {
-					return errors.New("no urls specified for web check " + ck.Name)
-				}
-				if len(ck.CredLists) < 1 {
-					ck.Anonymous = true
-				}
-				if ck.Scheme == "" {
-					ck.Scheme = "http"
-				}
-				for _, u := range ck.Url {
-					if u.Diff != 0 && u.CompareFile == "" {
-						return errors.New("need compare file for diff in web")
-					}
-				}
-				boxList[i].CheckList[j] = ck
-			case checks.WinRM:
-				ck := c.(checks.WinRM)
-				ck.IP = b.IP
-				if ck.Display == "" {
-					ck.Display = "winrm"
-				}
-				if ck.Name == "" {
-					ck.Name = b.Name + "-" + ck.Display
-				}
-				if ck.Port == 0 {
-					if ck.Encrypted {
-						ck.Port = 443
-					} else {
-						ck.Port = 80
-					}
-				}
-				if ck.Anonymous {
-					return errors.New("anonymous winrm not supported")
-				}
-				for _, r := range ck.Command {
-					if r.UseRegex {
-						regexp.MustCompile(r.Output)
-					}
-					if r.UseRegex && r.Contains {
-						return errors.New("cannot use both regex and contains")
-					}
-				}
-				boxList[i].CheckList[j] = ck
+			ck, err := checks.Setup(c, b.Name, b.checkIP)
+			if err != nil {
+				return err
+			}
+			if ck.FetchRetries() < 0 {
+				return errors.New("illegal config: retries can't be negative for check " + ck.FetchName())
+			}
+			if ck.FetchPoints() < 0 {
+				return errors.New("illegal config: points can't be negative for check " + ck.FetchName())
+			}
+			if port := ck.FetchPort(); port < 0 || port > 65535 {
+				return errors.New("illegal config: port " + strconv.Itoa(port) + " out of range for check " + ck.FetchName())
+			}
+			if ck.FetchIP() == "" {
+				return errors.New("illegal config: check " + ck.FetchName() + " uses ipv6, but box " + b.Name + " has no ip6")
+			}
+			boxList[i].CheckList[j] = ck
+		}
+	}
+	return nil
+}
+
+// checkDependencies makes sure checks only depend on checks that
+// exist, without cycles, and that every attempt of every check
+// (after the checks it waits on) fits in the shortest round.
+func checkDependencies(boxList []Box, roundTime time.Duration) error {
+	checkMap := make(map[string]checks.Check)
+	for _, b := range boxList {
+		for _, c := range b.CheckList {
+			checkMap[c.FetchName()] = c
+		}
+	}
+
+	// How long a check can take, including waiting on its dependencies
+	worstCase := make(map[string]time.Duration)
+	visiting := make(map[string]bool)
+	var visit func(c checks.Check) (time.Duration, error)
+	visit = func(c checks.Check) (time.Duration, error) {
+		name := c.FetchName()
+		if dur, ok := worstCase[name]; ok {
+			return dur, nil
+		}
+		if visiting[name] {
+			return 0, errors.New("illegal config: dependency cycle through check " + name)
+		}
+		visiting[name] = true
+		longest := time.Duration(0)
+		for _, depName := range c.FetchDependsOn() {
+			dep, ok := checkMap[depName]
+			if !ok {
+				return 0, errors.New("illegal config: check " + name + " depends on unknown check " + depName)
+			}
+			dur, err := visit(dep)
+			if err != nil {
+				return 0, err
+			}
+			if dur > longest {
+				longest = dur
+			}
+		}
+		visiting[name] = false
+		worstCase[name] = longest + c.FetchTimeout()*time.Duration(c.FetchRetries()+1)
+		return worstCase[name], nil
+	}
+
+	for _, b := range boxList {
+		for _, c := range b.CheckList {
+			dur, err := visit(c)
+			if err != nil {
+				return err
+			}
+			// One attempt at the global timeout is allowed, like before
+			// checks had their own timeouts. The global timeout is only
+			// compared to the round when it's set.
+			plain := len(c.FetchDependsOn()) == 0 && c.FetchRetries() == 0 && dur <= checks.GlobalTimeout
+			if dur >= roundTime && !plain {
+				msg := "illegal config: timeout times attempts for check " + c.FetchName()
+				if len(c.FetchDependsOn()) > 0 {
+					msg += " and the checks it depends on"
+				}
+				return errors.New(msg + " (" + dur.String() + ") not smaller than delay minus jitter")
 			}
 		}
 	}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/DSU-DefSec/DWAYNE-INATOR-5000/checks"
+)
+
+// testConfig has the tables every config needs. Settings go before
+// it, and boxes after.
+const testConfig = `
+[[admin]]
+name = "admin"
+pw = "admin"
+
+[[team]]
+ip = "1"
+pw = "team"
+
+[[creds]]
+name = "users"
+usernames = ["robin"]
+defaultpw = "hood"
+`
+
+// loadTestConfig reads and checks a config, the way the engine does at startup.
+func loadTestConfig(t *testing.T, settings, boxes string) (*config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dwayne.conf")
+	content := "event = \"test\"\n" + settings + testConfig + boxes
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	*configPath = path
+	configErrors = []string{}
+	conf := &config{}
+	dwConf = conf
+	readConfig(conf)
+	return conf, checkConfig(conf)
+}
+
+func TestCheckConfigTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		boxes    string
+		err      string
+	}{
+		{
+			name: "default timing",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.smb]]
+    [[box.ssh]]
+`,
+		},
+		{
+			name: "check timeout fits",
+			settings: `
+delay = 20
+jitter = 3
+timeout = 5
+`,
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+    timeout = 8
+    retries = 1
+`,
+		},
+		{
+			name: "check timeout too long",
+			settings: `
+delay = 20
+jitter = 3
+timeout = 5
+`,
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+    timeout = 9
+    retries = 1
+`,
+			err: "timeout times attempts for check castle-ssh (18s)",
+		},
+		{
+			name: "retries on the default timeout",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+    retries = 1
+`,
+			err: "timeout times attempts for check castle-ssh (1m0s)",
+		},
+		{
+			name: "global timeout too long",
+			settings: `
+delay = 20
+jitter = 3
+timeout = 17
+`,
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+`,
+			err: "timeout not smaller than delay minus jitter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.settings, tt.boxes)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeChecks(t *testing.T) {
+	tests := []struct {
+		name      string
+		boxes     string
+		checks    []string // check keys in order, for the first box
+		undecoded []string
+		err       string
+	}{
+		{
+			name: "registered keys",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+    [[box.ssh]]
+    port = 2222
+    [[box.DNS]]
+        [[box.DNS.record]]
+        kind = "A"
+        domain = "castle.sherwood.lan"
+        answer = ["10.20.x.1"]
+`,
+			checks: []string{"dns", "ssh", "ssh"},
+		},
+		{
+			name: "box without checks",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+`,
+			checks: []string{},
+		},
+		{
+			name: "unknown check type",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.sshh]]
+    port = 22
+`,
+			checks:    []string{},
+			undecoded: []string{"box.sshh", "box.sshh.port"},
+		},
+		{
+			name: "unknown check option",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+    colour = "green"
+`,
+			checks:    []string{"ssh"},
+			undecoded: []string{"box.ssh.colour"},
+		},
+		{
+			name: "unknown setting",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+colour = "green"
+`,
+			checks:    []string{},
+			undecoded: []string{"box.colour"},
+		},
+		{
+			name: "wrong type",
+			boxes: `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.ssh]]
+    port = "twenty two"
+`,
+			err: "box castle ssh checks:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "event = \"test\"\n" + testConfig + tt.boxes
+			conf := &config{}
+			md, err := toml.Decode(content, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			undecoded, err := decodeChecks(md, content, conf.Box)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := []string{}
+			for _, c := range conf.Box[0].CheckList {
+				keys = append(keys, checks.Key(c))
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.checks, ",") {
+				t.Errorf("got checks %v, want %v", keys, tt.checks)
+			}
+			got := []string{}
+			for _, key := range undecoded {
+				got = append(got, key.String())
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.undecoded, ",") {
+				t.Errorf("got undecoded %v, want %v", got, tt.undecoded)
+			}
+		})
+	}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	defer func(timeout time.Duration) { checks.GlobalTimeout = timeout }(checks.GlobalTimeout)
+	checks.GlobalTimeout = 5 * time.Second
+
+	check := func(name string, timeout, retries int, dependsOn ...string) checks.Check {
+		return checks.Ntp{CheckBase: checks.CheckBase{Name: name, Timeout: timeout, Retries: retries, DependsOn: dependsOn}}
+	}
+
+	tests := []struct {
+		name   string
+		checks []checks.Check
+		err    string
+	}{
+		{
+			name:   "no dependencies",
+			checks: []checks.Check{check("dns", 0, 0), check("web", 4, 1)},
+		},
+		{
+			name:   "chain fits",
+			checks: []checks.Check{check("web", 3, 0, "dns"), check("dns", 3, 0), check("shop", 3, 0, "web")},
+		},
+		{
+			name:   "chain too long",
+			checks: []checks.Check{check("dns", 4, 0), check("web", 4, 0, "dns"), check("shop", 4, 0, "web")},
+			err:    "illegal config: timeout times attempts for check shop and the checks it depends on (12s) not smaller than delay minus jitter",
+		},
+		{
+			name:   "longest branch counts",
+			checks: []checks.Check{check("dns", 2, 0), check("ldap", 3, 2), check("web", 3, 0, "dns", "ldap")},
+			err:    "for check web and the checks it depends on (12s)",
+		},
+		{
+			name:   "retries too long",
+			checks: []checks.Check{check("dns", 4, 2)},
+			err:    "for check dns (12s)",
+		},
+		{
+			name:   "one attempt at the global timeout",
+			checks: []checks.Check{check("dns", 0, 0)},
+		},
+		{
+			name:   "unknown dependency",
+			checks: []checks.Check{check("web", 0, 0, "dsn")},
+			err:    "illegal config: check web depends on unknown check dsn",
+		},
+		{
+			name:   "cycle",
+			checks: []checks.Check{check("dns", 1, 0, "web"), check("web", 1, 0, "shop"), check("shop", 1, 0, "dns")},
+			err:    "illegal config: dependency cycle through check",
+		},
+		{
+			name:   "depends on itself",
+			checks: []checks.Check{check("dns", 1, 0, "dns")},
+			err:    "illegal config: dependency cycle through check dns",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Spread over two boxes, since dependencies can cross boxes
+			boxes := []Box{{Name: "castle"}, {Name: "village"}}
+			for i, c := range tt.checks {
+				boxes[i%2].CheckList = append(boxes[i%2].CheckList, c)
+			}
+			err := checkDependencies(boxes, 10*time.Second)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckConfigDependencyPolicy(t *testing.T) {
+	tests := []struct {
+		setting string
+		policy  string
+		err     bool
+	}{
+		{"", "strict", false},
+		{`dependencypolicy = "nosla"`, "nosla", false},
+		{`dependencypolicy = "forgive"`, "forgive", false},
+		{`dependencypolicy = "lenient"`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.setting, func(t *testing.T) {
+			conf, err := loadTestConfig(t, "timeout = 10\n"+tt.setting+"\n", `
+[[box]]
+name = "castle"
+ip = "10.20.x.1"
+    [[box.dns]]
+        [[box.dns.record]]
+        kind = "A"
+        domain = "castle.sherwood.lan"
+        answer = ["10.20.x.1"]
+    [[box.web]]
+    dependson = ["castle-dns"]
+        [[box.web.url]]
+`)
+			if tt.err {
+				if err == nil || !strings.Contains(err.Error(), "dependency policy") {
+					t.Fatalf("got error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.DependencyPolicy != tt.policy {
+				t.Errorf("policy %q, want %q", conf.DependencyPolicy, tt.policy)
+			}
+		})
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"log"
 	"strings"
 	"sync"
 	"time"
-	//"log"
 
 	"github.com/DSU-DefSec/DWAYNE-INATOR-5000/checks"
+	"gorm.io/gorm"
 )
 
 const (
@@ -29,6 +30,9 @@ type ResultEntry struct {
 	// Total points check has earned
 	Points int
 
+	// Earned is the service points the check got this round
+	Earned int
+
 	// Uptime is only used in the uptime view
 	Uptime int `gorm:"-"`
 
@@ -45,6 +49,7 @@ type TeamRecord struct {
 	ResultsMap       map[string]ResultEntry `gorm:"-"`
 	RedTeamPoints    int
 	ServicePoints    int
+	ServiceScore     int // service points weighted by each check's points
 	InjectPoints     int
 	SlaViolations    int
 	ManualAdjustment int
@@ -284,3 +289,29 @@ func constructPCRState() {
 	checks.Creds = ct.Creds
 	ct.Mutex.Unlock()
 }
+
+// migrateWeightedScores fills in the weighted scores for records from
+// before checks had their own points, when every up check was worth
+// servicepoints.
+func migrateWeightedScores(records, results bool) error {
+	global := db.Session(&gorm.Session{AllowGlobalUpdate: true})
+	if records {
+		log.Println("[INFO] Migrating team records to weighted service scores")
+		res := global.Model(&TeamRecord{}).Update("service_score", gorm.Expr("service_points * ?", dwConf.ServicePoints))
+		if res.Error != nil {
+			return res.Error
+		}
+	}
+	if results {
+		log.Println("[INFO] Migrating check results to weighted service scores")
+		res := global.Model(&ResultEntry{}).Update("weight", dwConf.ServicePoints)
+		if res.Error != nil {
+			return res.Error
+		}
+		res = db.Model(&ResultEntry{}).Where("status = ?", true).Update("earned", dwConf.ServicePoints)
+		if res.Error != nil {
+			return res.Error
+		}
+	}
+	return nil
+}
@@ -60,11 +60,8 @@ func debugPrint(a ...interface{}) {
 	}
 }
 
-func init() {
-	flag.Parse()
-}
-
 func main() {
+	flag.Parse()
 	readConfig(dwConf)
 	err := checkConfig(dwConf)
 	if err != nil {
@@ -89,8 +86,16 @@ func main() {
 		log.Fatal("Failed to connect database!")
 	}
 
+	// Databases from before weighted scoring need their scores filled in
+	legacyRecords := db.Migrator().HasTable(&TeamRecord{}) && !db.Migrator().HasColumn(&TeamRecord{}, "ServiceScore")
+	legacyResults := db.Migrator().HasTable(&ResultEntry{}) && !db.Migrator().HasColumn(&ResultEntry{}, "Earned")
+
 	db.AutoMigrate(&ResultEntry{}, &TeamRecord{}, &Inject{}, &InjectSubmission{}, &TeamData{}, &SLA{}, &Persist{})
 
+	if err := migrateWeightedScores(legacyRecords, legacyResults); err != nil {
+		log.Fatal("Failed to migrate database scores: ", err)
+	}
+
 	// Initialize manual adjustments map
 	manualAdjustments = make(map[uint]int)
 
@@ -310,14 +315,18 @@ func main() {
 	fileContent, err := os.ReadFile("./delayed-checks.conf")
 	if err == nil {
 		debugPrint("Adding delayed checks...")
-		if md, err := toml.Decode(string(fileContent), &delayedChecks); err != nil {
+		md, err := toml.Decode(string(fileContent), &delayedChecks)
+		if err != nil {
 			log.Fatalln(err)
-		} else {
-			for _, undecoded := range md.Undecoded() {
-				errMsg := "[WARN] Undecoded delayed checks configuration key \"" + undecoded.String() + "\" will not be used."
-				configErrors = append(configErrors, errMsg)
-				log.Println(errMsg)
-			}
+		}
+		undecodedKeys, err := decodeChecks(md, string(fileContent), delayedChecks.Box)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, undecoded := range undecodedKeys {
+			errMsg := "[WARN] Undecoded delayed checks configuration key \"" + undecoded.String() + "\" will not be used."
+			configErrors = append(configErrors, errMsg)
+			log.Println(errMsg)
 		}
 		for _, b := range delayedChecks.Box {
 			if b.Time.IsZero() {
@@ -74,7 +74,7 @@ func graphScores(records []TeamRecord, darkmode bool) {
 		p.Legend.Add(rec.Team.Name, l)
 	}
 
-	c := vgimg.PngCanvas{vgimg.NewWith(
+	c := vgimg.PngCanvas{Canvas: vgimg.NewWith(
 		vgimg.UseWH(25*vg.Centimeter, 12*vg.Centimeter),
 		vgimg.UseBackgroundColor(color.Transparent),
 	)}
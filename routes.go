@@ -9,7 +9,6 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -711,7 +710,7 @@ func exportTeamData(c *gin.Context) {
 	csvString += "total\n"
 
 	var records []TeamRecord
-	res := db.Find(&records, "team = ?", team.Name)
+	res := db.Preload("Results").Order("time asc").Find(&records, "team_id = ?", team.ID)
 	if res.Error != nil {
 		errorOutGraceful(c, res.Error)
 		return
@@ -720,26 +719,29 @@ func exportTeamData(c *gin.Context) {
 	for _, r := range records {
 		csvString += r.Time.In(loc).Format("03:04:05 PM") + ","
 		csvString += strconv.Itoa(r.Round) + ","
-		csvString += strconv.Itoa(r.ServicePoints) + ","
+		csvString += strconv.Itoa(r.ServiceScore) + ","
 		csvString += strconv.Itoa(r.InjectPoints) + ","
-		statusString := ""
-		for _, c := range r.Results {
-			if c.Status {
-				statusString += "up,"
-			} else {
-				statusString += "down,"
+		// Points each check earned this round, in config order
+		earned := make(map[string]int)
+		for _, res := range r.Results {
+			earned[res.Name] = res.Earned
+		}
+		pointsString := ""
+		for _, b := range dwConf.Box {
+			for _, c := range b.CheckList {
+				pointsString += strconv.Itoa(earned[c.FetchName()]) + ","
 			}
 		}
 		csvString += "-" + strconv.Itoa(r.SlaViolations*dwConf.SlaPoints) + ","
-		csvString += statusString
-		csvString += strconv.Itoa(r.Total) + "\n"
+		csvString += pointsString
+		csvString += strconv.Itoa(calculateScoreTotal(r)) + "\n"
 	}
 	c.Data(200, "text/csv", []byte(csvString))
 }
 
 func viewSettings(c *gin.Context) {
 	buf := new(bytes.Buffer)
-	if err := toml.NewEncoder(buf).Encode(dwConf); err != nil {
+	if err := encodeSettings(buf, dwConf); err != nil {
 		c.HTML(http.StatusInternalServerError, "settings.html", pageData(c, "Settings", gin.H{"error": err}))
 		return
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"math"
 	"math/rand"
 	"sort"
 	"sync"
@@ -69,11 +70,18 @@ func Score(m *config) {
 						Round:  roundNumber,
 					}
 
+					// Checks wait on the checks they depend on
+					allChecks := []checks.Check{}
+					for _, b := range m.Box {
+						allChecks = append(allChecks, b.CheckList...)
+					}
+					round := checks.NewRound(allChecks)
+
 					for _, b := range m.Box {
 						for _, check := range b.CheckList {
 							wg.Add(1)
 							debugPrint("[SCORE] Running check for", team.Name, check)
-							go checks.RunCheck(team.ID, team.IP, b.IP, b.Name, check, wg, resChan)
+							go checks.RunCheck(team.ID, team.IP, check.FetchIP(), b.Name, check, round, wg, resChan)
 						}
 					}
 
@@ -93,12 +101,16 @@ func Score(m *config) {
 								TeamID: team.ID,
 								Round:  roundNumber,
 								Result: checks.Result{
-									Name:   res.Name,
-									Status: res.Status,
-									Error:  res.Error,
-									Debug:  res.Debug,
-									IP:     res.IP,
-									Box:    res.Box,
+									Name:      res.Name,
+									Status:    res.Status,
+									Error:     res.Error,
+									Debug:     res.Debug,
+									IP:        res.IP,
+									Box:       res.Box,
+									Attempts:  res.Attempts,
+									Partial:   res.Partial,
+									Weight:    res.Weight,
+									BlockedBy: res.BlockedBy,
 								},
 							}
 							newRecord.Results = append(newRecord.Results, resEntry)
@@ -239,7 +251,15 @@ func processNewRecord(rec *TeamRecord) {
 		}
 		rec.Results[i].Points = oldRes.Points
 		rec.Results[i].RoundCount = oldRes.RoundCount + 1
-		if !res.Status {
+		if !res.Status && res.BlockedBy != "" && dwConf.DependencyPolicy != "strict" {
+			// The check it depends on already counts against the team.
+			// Forgiven checks count as up, for points and uptime.
+			if dwConf.DependencyPolicy == "forgive" {
+				rec.ServicePoints++
+				rec.Results[i].Points++
+				rec.Results[i].Earned = res.Weight
+			}
+		} else if !res.Status {
 			slaRecord.Counter++
 			if slaRecord.Counter >= dwConf.SlaThreshold {
 				rec.SlaViolations++
@@ -247,11 +267,15 @@ func processNewRecord(rec *TeamRecord) {
 				slaRecord.Violations++
 				slaRecord.Counter = 0
 			}
+			// Down checks can still earn partial credit
+			rec.Results[i].Earned = int(math.Round(float64(res.Weight) * res.Partial))
 		} else {
 			slaRecord.Counter = 0
 			rec.ServicePoints++
 			rec.Results[i].Points++
+			rec.Results[i].Earned = res.Weight
 		}
+		rec.ServiceScore += rec.Results[i].Earned
 
 		if result = db.Save(&slaRecord); result.Error != nil {
 			errorPrint(result.Error)
@@ -269,6 +293,7 @@ func processNewRecord(rec *TeamRecord) {
 	rec.RedTeamPoints = currentRec.RedTeamPoints
 	rec.SlaViolations += currentRec.SlaViolations
 	rec.ServicePoints += currentRec.ServicePoints
+	rec.ServiceScore += currentRec.ServiceScore
 
 	// Calculate inject points
 	rec.InjectPoints = calculateInjects(currentRec)
@@ -329,9 +354,7 @@ func calculatePersists() {
 				totalPoints := 0
 				for _, res := range victim.Results {
 					if box == res.Box {
-						if res.Status {
-							totalPoints += dwConf.ServicePoints
-						}
+						totalPoints += res.Earned
 					}
 				}
 				distributedPoints := oneOfN(totalPoints, len(persists)+1)
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DSU-DefSec/DWAYNE-INATOR-5000/checks"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB points the engine at a fresh database with one team.
+func openTestDB(t *testing.T) TeamData {
+	t.Helper()
+	var err error
+	db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "dwayne.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&ResultEntry{}, &TeamRecord{}, &Inject{}, &InjectSubmission{}, &TeamData{}, &SLA{}, &Persist{}); err != nil {
+		t.Fatal(err)
+	}
+	manualAdjustments = make(map[uint]int)
+	team := TeamData{Name: "team01", IP: "1"}
+	if err := db.Create(&team).Error; err != nil {
+		t.Fatal(err)
+	}
+	return team
+}
+
+// scoreRound records a round of results for the team, and returns
+// the record as saved.
+func scoreRound(t *testing.T, team TeamData, round int, results []checks.Result) TeamRecord {
+	t.Helper()
+	rec := TeamRecord{Time: time.Now(), TeamID: team.ID, Team: team, Round: round}
+	for _, res := range results {
+		rec.Results = append(rec.Results, ResultEntry{Time: rec.Time, TeamID: team.ID, Round: round, Result: res})
+	}
+	processNewRecord(&rec)
+
+	var saved TeamRecord
+	if err := db.Preload("Results").Order("time desc").First(&saved, "team_id = ?", team.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
+
+func TestProcessNewRecord(t *testing.T) {
+	round := []checks.Result{
+		{Name: "castle-dns", Status: true, Weight: 3},
+		{Name: "castle-web", Error: "down", Partial: 0.5, Weight: 4},
+		{Name: "castle-ssh", Error: "down", Weight: 1},
+		{Name: "castle-smb", Error: "blocked", BlockedBy: "castle-ssh", Weight: 2},
+	}
+
+	tests := []struct {
+		policy        string
+		servicePoints int
+		serviceScore  int
+		slaViolations int
+		earned        map[string]int
+		points        map[string]int
+	}{
+		{
+			policy:        "strict",
+			servicePoints: 1,
+			serviceScore:  3 + 2,
+			slaViolations: 3,
+			earned:        map[string]int{"castle-dns": 3, "castle-web": 2, "castle-ssh": 0, "castle-smb": 0},
+			points:        map[string]int{"castle-dns": 1, "castle-web": 0, "castle-ssh": 0, "castle-smb": 0},
+		},
+		{
+			policy:        "nosla",
+			servicePoints: 1,
+			serviceScore:  3 + 2,
+			slaViolations: 2,
+			earned:        map[string]int{"castle-dns": 3, "castle-web": 2, "castle-ssh": 0, "castle-smb": 0},
+			points:        map[string]int{"castle-dns": 1, "castle-web": 0, "castle-ssh": 0, "castle-smb": 0},
+		},
+		{
+			policy:        "forgive",
+			servicePoints: 2,
+			serviceScore:  3 + 2 + 2,
+			slaViolations: 2,
+			earned:        map[string]int{"castle-dns": 3, "castle-web": 2, "castle-ssh": 0, "castle-smb": 2},
+			points:        map[string]int{"castle-dns": 1, "castle-web": 0, "castle-ssh": 0, "castle-smb": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			team := openTestDB(t)
+			dwConf = &config{SlaThreshold: 1, DependencyPolicy: tt.policy}
+
+			rec := scoreRound(t, team, 1, round)
+			if rec.ServicePoints != tt.servicePoints || rec.ServiceScore != tt.serviceScore || rec.SlaViolations != tt.slaViolations {
+				t.Errorf("round 1: service points %d, score %d, sla violations %d", rec.ServicePoints, rec.ServiceScore, rec.SlaViolations)
+			}
+			for _, res := range rec.Results {
+				if res.Earned != tt.earned[res.Name] || res.Points != tt.points[res.Name] || res.RoundCount != 1 {
+					t.Errorf("round 1 %s: earned %d, points %d, round count %d", res.Name, res.Earned, res.Points, res.RoundCount)
+				}
+			}
+
+			// Totals carry over to the next round
+			up := []checks.Result{}
+			for _, res := range round {
+				up = append(up, checks.Result{Name: res.Name, Status: true, Weight: res.Weight})
+			}
+			rec = scoreRound(t, team, 2, up)
+			if rec.ServicePoints != tt.servicePoints+4 || rec.ServiceScore != tt.serviceScore+10 || rec.SlaViolations != tt.slaViolations {
+				t.Errorf("round 2: service points %d, score %d, sla violations %d", rec.ServicePoints, rec.ServiceScore, rec.SlaViolations)
+			}
+			for _, res := range rec.Results {
+				if res.Points != tt.points[res.Name]+1 || res.RoundCount != 2 {
+					t.Errorf("round 2 %s: points %d, round count %d", res.Name, res.Points, res.RoundCount)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessNewRecordSla(t *testing.T) {
+	team := openTestDB(t)
+	dwConf = &config{SlaThreshold: 3, DependencyPolicy: "strict"}
+
+	// A violation needs SlaThreshold downs in a row
+	statuses := []bool{false, false, true, false, false, false, false}
+	violations := []int{0, 0, 0, 0, 0, 1, 1}
+	for i, status := range statuses {
+		rec := scoreRound(t, team, i+1, []checks.Result{{Name: "castle-ssh", Status: status, Weight: 1}})
+		if rec.SlaViolations != violations[i] {
+			t.Errorf("round %d: %d sla violations, want %d", i+1, rec.SlaViolations, violations[i])
+		}
+	}
+}
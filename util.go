@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"regexp"
@@ -92,6 +93,14 @@ func addDelayedChecks() {
 
 			delayedBox = boxList[0]
 
+			// Delayed checks can depend on checks that are already running
+			allBoxes := append(append([]Box{}, dwConf.Box...), delayedBox)
+			err = checkDependencies(allBoxes, time.Duration(dwConf.Delay-dwConf.Jitter)*time.Second)
+			if err != nil {
+				log.Println("[ERROR] Dependency validation on delayed check:", delayedBox.Name, err)
+				continue
+			}
+
 			boxIndex := -1
 			for j, b := range dwConf.Box {
 				if b.Name == delayedBox.Name {
@@ -146,7 +155,7 @@ func (m *config) getCheck(checkName string) (checks.Check, error) {
 }
 
 func calculateScoreTotal(rec TeamRecord) int {
-	total := (rec.ServicePoints * dwConf.ServicePoints) + rec.InjectPoints
+	total := rec.ServiceScore + rec.InjectPoints
 	total -= rec.RedTeamPoints + (rec.SlaViolations * dwConf.SlaPoints)
 	if dwConf.Persists {
 		total += rec.PointsStolen + rec.PersistPoints
@@ -166,10 +175,18 @@ func readInject(inj InjectSubmission) string {
 }
 
 func boxFromIP(ip string) (TeamData, string, error) {
+	// Compare parsed addresses, since ipv6 has many ways to write the same address
+	remoteIP := net.ParseIP(ip)
 	for _, box := range dwConf.Box {
 		for _, t := range dwConf.Team {
-			if ip == strings.Replace(box.IP, "x", t.IP, 1) {
-				return t, box.Name, nil
+			for _, boxIP := range []string{box.IP, box.IP6} {
+				if boxIP == "" {
+					continue
+				}
+				fullIP := strings.Replace(boxIP, "x", t.IP, 1)
+				if ip == fullIP || (remoteIP != nil && remoteIP.Equal(net.ParseIP(fullIP))) {
+					return t, box.Name, nil
+				}
 			}
 		}
 	}